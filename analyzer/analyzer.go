@@ -1,7 +1,8 @@
 package analyzer
 
 import (
-	"os"
+	"errors"
+	"io"
 	"strings"
 
 	"github.com/hlmerscher/jack-compiler-go/engine"
@@ -9,17 +10,134 @@ import (
 	"github.com/hlmerscher/jack-compiler-go/vm"
 )
 
-func Compile(file *os.File, out *strings.Builder) error {
+// File is a jack source, named in the diagnostics by its name.
+type File interface {
+	io.Reader
+	Name() string
+}
+
+// Result is what the compilation tells about a class, besides its vm code.
+type Result struct {
+	ClassName   string
+	Warnings    []*engine.Diagnostic
+	Subroutines []engine.Signature
+	Constants   map[string]engine.Constant
+	// other classes the class depends on
+	References []string
+	// what other classes can use of the class
+	Def engine.ClassDef
+}
+
+// Compile compiles the class in the file into vm code.
+func Compile(file File, out *strings.Builder, opts engine.Options) (result Result, err error) {
+	defer locate(file, &err)
+	defer engine.Recover(&err)
+
 	tk := tokenizer.New(file)
+	tk.CharLiterals = opts.Has(engine.CharLiterals)
 	if _, err := tk.Advance(); err != nil {
-		return err
+		return result, err
 	}
 
 	vmBuf := vm.New(out)
-	compiler := engine.New(vmBuf)
-	if err := compiler.Class(&tk); err != nil {
-		return err
+	compiler := engine.New(vmBuf, opts)
+	defer func() {
+		result = Result{
+			ClassName:   compiler.ClassName(),
+			Warnings:    compiler.Warnings(),
+			Subroutines: compiler.Signatures(),
+			Constants:   compiler.Constants(),
+			References:  compiler.References(),
+			Def:         compiler.ClassDef(),
+		}
+		for _, warning := range result.Warnings {
+			warning.File = file.Name()
+		}
+	}()
+
+	return result, compiler.Class(&tk)
+}
+
+// Declarations reads the descriptor of the class in the file, from its declarations alone.
+func Declarations(file File, opts engine.Options) (def engine.ClassDef, err error) {
+	defer locate(file, &err)
+	defer engine.Recover(&err)
+
+	tk := tokenizer.New(file)
+	tk.CharLiterals = opts.Has(engine.CharLiterals)
+	if _, err := tk.Advance(); err != nil {
+		return engine.ClassDef{}, err
 	}
 
+	compiler := engine.New(vm.New(new(strings.Builder)), opts)
+	return compiler.ClassDeclarations(&tk)
+}
+
+// Tokens writes the tokens of the file as xml, one per line, like <keyword> class </keyword>.
+func Tokens(file File, out *strings.Builder, opts engine.Options) (err error) {
+	defer locate(file, &err)
+
+	tk := tokenizer.New(file)
+	tk.CharLiterals = opts.Has(engine.CharLiterals)
+
+	out.WriteString("<tokens>\n")
+	for {
+		token, err := tk.Advance()
+		if err != nil {
+			return err
+		}
+		if !tk.HasMoreTokens() {
+			break
+		}
+		if token.Type == tokenizer.UNKNOWN {
+			return engine.UnknownTokenError(&tk, token)
+		}
+		out.WriteString(token.XML() + "\n")
+	}
+	out.WriteString("</tokens>\n")
+
 	return nil
 }
+
+// SyntaxTree compiles the class in the file, writing its parse tree as xml instead of the vm code.
+func SyntaxTree(file File, out *strings.Builder, opts engine.Options) (err error) {
+	defer locate(file, &err)
+	defer engine.Recover(&err)
+
+	tk := tokenizer.New(file)
+	tk.CharLiterals = opts.Has(engine.CharLiterals)
+	if _, err := tk.Advance(); err != nil {
+		return err
+	}
+
+	compiler := engine.New(vm.New(new(strings.Builder)), opts)
+	compiler.WriteSyntaxTree(out)
+	return compiler.Class(&tk)
+}
+
+// locate sets the file of the diagnostic returned, the compiler only knows about the tokens.
+func locate(file File, err *error) {
+	var d *engine.Diagnostic
+	if errors.As(*err, &d) {
+		d.File = file.Name()
+	}
+}
+
+// Symbols compiles the class in the file, writing its symbol table, the class scope followed
+// by the scope of every subroutine.
+func Symbols(file File, out *strings.Builder, opts engine.Options) (err error) {
+	defer locate(file, &err)
+	defer engine.Recover(&err)
+
+	tk := tokenizer.New(file)
+	tk.CharLiterals = opts.Has(engine.CharLiterals)
+	if _, err := tk.Advance(); err != nil {
+		return err
+	}
+
+	compiler := engine.New(vm.New(new(strings.Builder)), opts)
+	if err := compiler.Class(&tk); err != nil {
+		return err
+	}
+	return compiler.Symbols().Dump(out)
+}
@@ -0,0 +1,95 @@
+package analyzer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hlmerscher/jack-compiler-go/engine"
+)
+
+// source is a jack class in memory.
+type source struct {
+	*strings.Reader
+}
+
+func (source) Name() string {
+	return "Main.jack"
+}
+
+// screen is the descriptor of the qualified calls checked.
+var screen = engine.ClassDef{
+	Class: "Screen",
+	Subroutines: []engine.Signature{
+		{Kind: "function", Name: "drawPixel", ReturnType: "void", Params: []string{"int", "int"}},
+	},
+}
+
+func compile(t *testing.T, extensions, class string) (Result, error) {
+	t.Helper()
+	exts, err := engine.ParseExtensions(extensions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := engine.Options{Extensions: exts, Strict: true, Defs: map[string]engine.ClassDef{"Screen": screen}}
+	return Compile(source{strings.NewReader(class)}, new(strings.Builder), opts)
+}
+
+func TestDiagnostics(t *testing.T) {
+	tests := []struct {
+		name       string
+		extensions string
+		body       string
+		// the code of the error, or of the only warning, none when it compiles cleanly
+		code engine.Code
+	}{
+		{name: "compiles", body: "function void main() { var int x; let x = 1 + 2; return; }"},
+		{name: "unexpected token", body: "function void main() { let = 1; return; }", code: engine.UnexpectedToken},
+		{name: "missing expression", body: "function void main() { var int x; let x = ; return; }", code: engine.UnexpectedToken},
+		{name: "integer out of range", body: "function int main() { return 32768; }", code: engine.InvalidLiteral},
+		{name: "undeclared variable", body: "function void main() { var int width, x; let x = widht; return; }", code: engine.UndeclaredVariable},
+		{name: "undeclared capitalized variable", body: "function void main() { var int x; let x = Widht; return; }", code: engine.UndeclaredVariable},
+		{name: "assignment to a class name", body: "function void main() { let Foo = 1; return; }", code: engine.UndeclaredVariable},
+		{name: "call into another class", body: "function void main() { do Foo.bar(); return; }"},
+		{name: "qualified call", body: "function void main() { do Screen.drawPixel(1, 2); return; }"},
+		{name: "undeclared subroutine of a class", body: "function void main() { do Screen.drawPixle(1, 2); return; }", code: engine.UndeclaredSubroutine},
+		{name: "argument count", body: "function void main() { do Screen.drawPixel(1); return; }", code: engine.WrongArgumentCount},
+		{name: "function called on an object", body: "function void main() { var Screen s; do s.drawPixel(1, 2); return; }", code: engine.InvalidCall},
+		{name: "unqualified argument count", body: "method void main() { do f(1); return; } method void f(int a, int b) { return; }", code: engine.WrongArgumentCount},
+		{name: "method from a function", body: "function void main() { do f(); return; } method void f() { return; }", code: engine.NoThisObject},
+		{name: "field from a function", body: "field int x; function void main() { let x = 1; return; }", code: engine.NoThisObject},
+		{name: "value from void", body: "function void main() { return 1; }", code: engine.InvalidReturn},
+		{name: "missing return", body: "function int main() { var int x; if (x) { return 1; } }", code: engine.MissingReturn},
+		{name: "return on both branches", body: "function int main() { var int x; if (x) { return 1; } else { return 2; } }"},
+		{name: "endless loop", body: "function int main() { while (true) { } }"},
+		{name: "duplicate declaration", body: "function void main() { var int x, x; return; }", code: engine.DuplicateDeclaration},
+		{name: "evaluation order", body: "function int main() { return 1 + 2 * 3; }", code: engine.EvaluationOrder},
+		{name: "break outside a loop", extensions: "loops", body: "function void main() { break; return; }", code: engine.InvalidJump},
+		{name: "for loop", extensions: "loops", body: "function void main() { var int i; for (i = 0; i < 3; i = i + 1) { continue; } return; }"},
+		{name: "switch", extensions: "branches", body: "function void main() { var int x; switch (x) { case 1: let x = 2; default: let x = 3; } return; }"},
+		{name: "duplicate case", extensions: "branches", body: "function void main() { var int x; switch (x) { case 1: case 1: } return; }", code: engine.InvalidSwitch},
+		{name: "constant", extensions: "consts", body: "const int W = 512; function int main() { return W; }"},
+		{name: "assignment to a constant", extensions: "consts", body: "const int W = 512; function void main() { let W = 1; return; }", code: engine.AssignToConstant},
+		{name: "constant of a string", extensions: "consts", body: `const int W = "x"; function void main() { return; }`, code: engine.InvalidLiteral},
+		{name: "constant of the wrong type", extensions: "consts", body: "const boolean B = 7; function void main() { return; }", code: engine.InvalidLiteral},
+		{name: "compound assignment", extensions: "compound", body: "function void main() { var int x; let x += 2; let x++; return; }"},
+		{name: "char literal", extensions: "chars", body: "function char main() { return '\\''; }"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := compile(t, test.extensions, "class Main { "+test.body+" }")
+			var codes []engine.Code
+			for _, warning := range result.Warnings {
+				codes = append(codes, warning.Code)
+			}
+			if err != nil {
+				codes = append(codes, engine.AsDiagnostic(err, "").Code)
+			}
+			switch {
+			case test.code == "" && len(codes) > 0:
+				t.Errorf("got %v, %v, want no diagnostics", codes, err)
+			case test.code != "" && (len(codes) != 1 || codes[0] != test.code):
+				t.Errorf("got %v, %v, want %s", codes, err, test.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/hlmerscher/jack-compiler-go/analyzer"
+	"github.com/hlmerscher/jack-compiler-go/engine"
+	"github.com/hlmerscher/jack-compiler-go/jackos"
+	"github.com/hlmerscher/jack-compiler-go/logger"
+	"golang.org/x/exp/slices"
+)
+
+func buildCommand(args []string) int {
+	var filename, dirname string
+	var verbose, useCache, clean, watchMode bool
+	var jobs int
+	var interval time.Duration
+	var execCommand string
+	flags := newFlagSet("build", "[files or directories]")
+	flags.StringVar(&filename, "f", "", "the filename of a jack source file")
+	flags.StringVar(&dirname, "d", "", "a directory of jack source files, searched recursively")
+	cf := addCompileFlags(flags)
+	cf.addOSFlag(flags)
+	flags.StringVar(&outputDir, "o", "", "the directory of the vm files, mirroring the source tree, instead of next to the sources")
+	flags.BoolVar(&writeDefs, "jackdef", false, "also write a .jackdef file per class, describing it for the compilations using it as a precompiled class")
+	flags.BoolVar(&toStdout, "stdout", false, "write the vm code to the standard output instead of files")
+	flags.BoolVar(&verbose, "v", false, "verbose output")
+	flags.IntVar(&jobs, "j", 1, "number of files compiled concurrently, 0 uses all cpus")
+	flags.BoolVar(&useCache, "cache", false, "only recompile the classes changed since the last build, and the ones depending on them")
+	flags.BoolVar(&clean, "clean", false, "discard the build cache, recompiling everything")
+	flags.BoolVar(&watchMode, "watch", false, "keep running, recompiling the files changed, uses the build cache")
+	flags.DurationVar(&interval, "interval", 500*time.Millisecond, "how often files are checked for changes in watch mode")
+	flags.StringVar(&execCommand, "exec", "", "shell command to run after each successful build in watch mode, e.g. a test program")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	paths := flags.Args()
+	if filename != "" {
+		paths = append(paths, filename)
+	}
+	if dirname != "" {
+		paths = append(paths, dirname)
+	}
+	if len(paths) == 0 {
+		return usageError(flags, "no files or directories given")
+	}
+	if err := cf.apply(); err != nil {
+		return usageError(flags, err.Error())
+	}
+	logger.Toggle(verbose)
+
+	find := func() ([]source, error) {
+		return cf.sources(paths)
+	}
+	if jobs <= 0 {
+		jobs = runtime.NumCPU()
+	}
+
+	cacheDir := filepath.Join(projectRoot(paths), cacheDirname)
+	if clean {
+		logger.Errorf("error cleaning the build cache\n", os.RemoveAll(cacheDir))
+	}
+
+	if watchMode {
+		compact = true
+		watch(find, cacheDir, jobs, interval, execCommand)
+		return exitOK
+	}
+
+	sources, err := cf.discover(paths)
+	if err != nil {
+		return sourcesError(flags, err)
+	}
+	var failed int
+	if useCache && !toStdout {
+		failed, err = incrementalBuild(cacheDir, sources, jobs)
+		logger.Errorf("error updating the build cache\n", err)
+	} else {
+		failed = failures(analyzeFiles(sources, jobs))
+	}
+	flushDiagnostics()
+	if failed > 0 {
+		logger.Fail("", fmt.Errorf("%d of %d files failed to compile", failed, len(sources)))
+		return exitFailed
+	}
+	return exitOK
+}
+
+func checkCommand(args []string) int {
+	var jobs int
+	flags := newFlagSet("check", "<files or directories>")
+	cf := addCompileFlags(flags)
+	cf.addOSFlag(flags)
+	flags.IntVar(&jobs, "j", 1, "number of files checked concurrently, 0 uses all cpus")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	if flags.NArg() == 0 {
+		return usageError(flags, "no files or directories given")
+	}
+	if err := cf.apply(); err != nil {
+		return usageError(flags, err.Error())
+	}
+	if jobs <= 0 {
+		jobs = runtime.NumCPU()
+	}
+	checkOnly = true
+
+	sources, err := cf.discover(flags.Args())
+	if err != nil {
+		return sourcesError(flags, err)
+	}
+	failed := failures(analyzeFiles(sources, jobs))
+	flushDiagnostics()
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "%d of %d files failed to compile\n", failed, len(sources))
+		return exitFailed
+	}
+	return exitOK
+}
+
+// discoverSources collects the jack files given, and the ones within the directories given,
+// followed by the OS classes without sources among them when the OS is compiled along.
+func discoverSources(paths []string, include, exclude []string, withOS bool) ([]source, error) {
+	var sources []source
+	var filenames []string
+	for _, name := range paths {
+		info, err := statPath(name)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			sources = append(sources, newSource(name, ""))
+			filenames = append(filenames, name)
+			continue
+		}
+
+		dirname := strings.TrimSuffix(name, "/")
+		dirFiles, err := dirFilenames(dirname, ".jack", include, exclude)
+		if err != nil {
+			return nil, fmt.Errorf("error reading directory\n%w", err)
+		}
+		for _, filename := range dirFiles {
+			sources = append(sources, newSource(filename, dirname))
+		}
+		filenames = append(filenames, dirFiles...)
+	}
+	classNames := make([]string, 0, len(sources))
+	for _, filename := range filenames {
+		classNames = append(classNames, strings.TrimSuffix(filepath.Base(filename), ".jack"))
+	}
+	if withOS {
+		root := "."
+		if len(paths) > 0 {
+			root = projectRoot(paths)
+		}
+		for _, className := range jackos.Classes() {
+			if !slices.Contains(classNames, className) {
+				sources = append(sources, newOSSource(className, root))
+				classNames = append(classNames, className)
+			}
+		}
+	}
+	return sources, nil
+}
+
+// scopes are the compiler options of the sources of each root, by root, the programs in
+// different directories compiled together being unrelated, like a Main class in each.
+var scopes map[string]engine.Options
+
+// declare sets the descriptors of the classes of each source root, along with the OS classes
+// compiled and the precompiled ones, as the ones the calls of the root are checked against,
+// and their constants as the ones inlined.
+func declare(sources []source) error {
+	rootDefs, err := projectDefs(sources)
+	if err != nil {
+		return fmt.Errorf("error reading the declarations\n%w", err)
+	}
+	// the roots whose classes all fail to parse see the others still
+	for _, src := range sources {
+		if _, ok := rootDefs[src.root]; !ok {
+			rootDefs[src.root] = nil
+		}
+	}
+	scopes = make(map[string]engine.Options, len(rootDefs))
+	for root, classDefs := range rootDefs {
+		scoped := opts
+		scoped.Defs = make(map[string]engine.ClassDef)
+		for _, defs := range []map[string]engine.ClassDef{libraryDefs, rootDefs[""], classDefs} {
+			for className, def := range defs {
+				scoped.Defs[className] = def
+			}
+		}
+		if opts.Has(engine.Constants) {
+			scoped.Constants = make(map[string]map[string]engine.Constant, len(scoped.Defs))
+			for className, def := range scoped.Defs {
+				scoped.Constants[className] = def.Constants
+			}
+		}
+		scopes[root] = scoped
+	}
+	return nil
+}
+
+// options are the compiler options of the source, with the descriptors of the classes of its root.
+func (s source) options() engine.Options {
+	if scoped, ok := scopes[s.root]; ok {
+		return scoped
+	}
+	return opts
+}
+
+// compilation is the outcome of compiling a single file, reported once all files before it are reported.
+type compilation struct {
+	source
+	code   string
+	result analyzer.Result
+	err    error
+	done   chan struct{}
+}
+
+// analyzeFiles compiles the files with a pool of workers, reporting them in the given order.
+func analyzeFiles(sources []source, jobs int) []*compilation {
+	compilations := make([]*compilation, len(sources))
+	queue := make(chan *compilation)
+	for i, src := range sources {
+		compilations[i] = &compilation{source: src, done: make(chan struct{})}
+	}
+	go func() {
+		for _, comp := range compilations {
+			queue <- comp
+		}
+		close(queue)
+	}()
+	for i := 0; i < jobs; i++ {
+		go func() {
+			for comp := range queue {
+				comp.code, comp.result, comp.err = analyzeFile(comp.source)
+				close(comp.done)
+			}
+		}()
+	}
+
+	for _, comp := range compilations {
+		<-comp.done
+
+		if compact || checkOnly || diagnosticsFormat != textFormat {
+			report(comp.filename, comp.result.Warnings, comp.err)
+			if toStdout && comp.err == nil {
+				fmt.Print(comp.code)
+			}
+			continue
+		}
+
+		if !toStdout {
+			fmt.Printf("input:\t%s\n", comp.filename)
+		}
+		report(comp.filename, comp.result.Warnings, comp.err)
+		if comp.err != nil {
+			continue
+		}
+		if toStdout {
+			fmt.Print(comp.code)
+		} else {
+			fmt.Printf("output:\t%s\n", comp.outputFilename)
+		}
+	}
+
+	return compilations
+}
+
+func failures(compilations []*compilation) int {
+	var failed int
+	for _, comp := range compilations {
+		if comp.err != nil {
+			failed++
+		}
+	}
+	return failed
+}
+
+func analyzeFile(src source) (string, analyzer.Result, error) {
+	sourceFile, err := src.open()
+	if err != nil {
+		return "", analyzer.Result{}, fmt.Errorf("error opening file\n%w", err)
+	}
+	defer sourceFile.Close()
+
+	out := new(strings.Builder)
+	result, err := analyzer.Compile(sourceFile, out, src.options())
+	if err != nil {
+		return "", result, err
+	}
+	if toStdout || checkOnly {
+		return out.String(), result, nil
+	}
+
+	if writeDefs {
+		if err := writeDef(src.outputFilename, result.Def); err != nil {
+			return out.String(), result, err
+		}
+	}
+	return out.String(), result, writeToFile(src.outputFilename, out.String())
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/hlmerscher/jack-compiler-go/analyzer"
+)
+
+const (
+	cacheDirname  = ".jackcache"
+	cacheFilename = "build.json"
+)
+
+// buildCache remembers, for every source file, what it looked like when last compiled.
+type buildCache struct {
+	// hash of the compiler options, changing them rebuilds everything
+	Options string                 `json:"options"`
+	Files   map[string]*cachedFile `json:"files"`
+}
+
+type cachedFile struct {
+	Hash       string   `json:"hash"`
+	Class      string   `json:"class"`
+	Interface  string   `json:"interface"`
+	References []string `json:"references"`
+}
+
+// projectRoot is where the build cache is kept, the first source directory, or the directory of the first source file.
+func projectRoot(paths []string) string {
+	if info, err := os.Stat(paths[0]); err == nil && info.IsDir() {
+		return paths[0]
+	}
+	return filepath.Dir(paths[0])
+}
+
+func loadCache(cacheDir string) (*buildCache, error) {
+	cache := &buildCache{Files: make(map[string]*cachedFile)}
+	content, err := os.ReadFile(filepath.Join(cacheDir, cacheFilename))
+	if errors.Is(err, fs.ErrNotExist) {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, cache); err != nil {
+		// a corrupted cache only costs a full rebuild
+		return &buildCache{Files: make(map[string]*cachedFile)}, nil
+	}
+	return cache, nil
+}
+
+func (cache *buildCache) save(cacheDir string) error {
+	content, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(cacheDir, 0777); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(cacheDir, cacheFilename), content, 0666)
+}
+
+// incrementalBuild compiles the sources changed since the last build, then the unchanged sources
+// referencing a class whose subroutine signatures or constants changed, and returns how many failed.
+func incrementalBuild(cacheDir string, sources []source, jobs int) (int, error) {
+	cache, err := loadCache(cacheDir)
+	if err != nil {
+		return 0, err
+	}
+	// the descriptors of the project classes change along with their sources, rebuilding the
+	// classes depending on them below, only the precompiled ones given by -defs are options
+	options := opts.WithoutProject()
+	options.Defs = libraryDefs
+	optionsHash := hashOf(options)
+	if cache.Options != optionsHash {
+		cache = &buildCache{Options: optionsHash, Files: make(map[string]*cachedFile)}
+	}
+
+	hashes := make(map[string]string)
+	reasons := make(map[string]string)
+	var changed, unchanged []source
+	for _, src := range sources {
+		content, err := src.read()
+		if err != nil {
+			// reported when the file is compiled
+			reasons[src.filename] = "unreadable"
+			changed = append(changed, src)
+			continue
+		}
+		hashes[src.filename] = hashOf(content)
+
+		cached, ok := cache.Files[src.filename]
+		_, statErr := os.Stat(src.outputFilename)
+		switch {
+		case !ok:
+			reasons[src.filename] = "new"
+		case cached.Hash != hashes[src.filename]:
+			reasons[src.filename] = "changed"
+		case statErr != nil:
+			reasons[src.filename] = "output missing"
+		default:
+			unchanged = append(unchanged, src)
+			continue
+		}
+		changed = append(changed, src)
+	}
+
+	compilations := analyzeFiles(changed, jobs)
+
+	// classes whose interface changed, by name
+	interfaceChanged := make(map[string]bool)
+	for _, comp := range compilations {
+		previous := cache.Files[comp.filename]
+		if comp.err != nil {
+			delete(cache.Files, comp.filename)
+			if previous != nil {
+				interfaceChanged[previous.Class] = true
+			}
+			continue
+		}
+		current := newCachedFile(hashes[comp.filename], comp.result)
+		if previous == nil || previous.Interface != current.Interface || previous.Class != current.Class {
+			interfaceChanged[current.Class] = true
+		}
+		cache.Files[comp.filename] = current
+	}
+
+	var dependents []source
+	for _, src := range unchanged {
+		for _, className := range cache.Files[src.filename].References {
+			if interfaceChanged[className] {
+				reasons[src.filename] = fmt.Sprintf("interface of %s changed", className)
+				dependents = append(dependents, src)
+				break
+			}
+		}
+	}
+	dependentCompilations := analyzeFiles(dependents, jobs)
+	for _, comp := range dependentCompilations {
+		if comp.err != nil {
+			delete(cache.Files, comp.filename)
+			continue
+		}
+		cache.Files[comp.filename] = newCachedFile(hashes[comp.filename], comp.result)
+	}
+	compilations = append(compilations, dependentCompilations...)
+
+	reportBuild(compilations, reasons, len(sources))
+
+	return failures(compilations), cache.save(cacheDir)
+}
+
+func newCachedFile(hash string, result analyzer.Result) *cachedFile {
+	return &cachedFile{
+		Hash:       hash,
+		Class:      result.ClassName,
+		Interface:  hashOf([]any{result.Subroutines, result.Constants}),
+		References: result.References,
+	}
+}
+
+func reportBuild(compilations []*compilation, reasons map[string]string, total int) {
+	if compact {
+		return
+	}
+	sort.Slice(compilations, func(i, j int) bool {
+		return compilations[i].filename < compilations[j].filename
+	})
+	for _, comp := range compilations {
+		fmt.Printf("rebuilt:\t%s (%s)\n", comp.filename, reasons[comp.filename])
+	}
+	fmt.Printf("cache:\t%d rebuilt, %d up to date\n", len(compilations), total-len(compilations))
+}
+
+// hashOf hashes raw bytes, or the json encoding of any other value.
+func hashOf(value any) string {
+	content, ok := value.([]byte)
+	if !ok {
+		content, _ = json.Marshal(value)
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
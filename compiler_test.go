@@ -0,0 +1,207 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/hlmerscher/jack-compiler-go/analyzer"
+	"github.com/hlmerscher/jack-compiler-go/emulator"
+	"github.com/hlmerscher/jack-compiler-go/engine"
+)
+
+var update = flag.Bool("update", false, "rewrite the expected files of testdata with the output of the compiler, only to bless a change on purpose")
+
+// programs run at most that many vm instructions when their vm code is compared, some never end
+const runMaxSteps = 2000000
+
+// TestPrograms compiles the programs of testdata, one directory each, comparing what the vm code
+// of its classes does with what the Xxx.vm files written by the compiler of the nand2tetris
+// course do, their labels and instructions differing, and the parse tree and tokens of every
+// class with the Xxx.xml and XxxT.xml files of the course. A reference file missing fails the
+// program.
+func TestPrograms(t *testing.T) {
+	dirs, err := filepath.Glob(filepath.Join("testdata", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range dirs {
+		dir := dir
+		t.Run(filepath.Base(dir), func(t *testing.T) {
+			filenames, err := filepath.Glob(filepath.Join(dir, "*.jack"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(filenames) == 0 {
+				t.Skip("no jack files")
+			}
+			expected, actual := make(map[string]string), make(map[string]string)
+			var missing []string
+			for _, filename := range filenames {
+				className := strings.TrimSuffix(filepath.Base(filename), ".jack")
+				outputs := map[string]func(analyzer.File, *strings.Builder) error{
+					".vm": func(file analyzer.File, out *strings.Builder) error {
+						_, err := analyzer.Compile(file, out, engine.Options{})
+						return err
+					},
+					".xml": func(file analyzer.File, out *strings.Builder) error {
+						return analyzer.SyntaxTree(file, out, engine.Options{})
+					},
+					"T.xml": func(file analyzer.File, out *strings.Builder) error {
+						return analyzer.Tokens(file, out, engine.Options{})
+					},
+				}
+				for suffix, output := range outputs {
+					out, err := compileFile(filename, output)
+					if err != nil {
+						t.Fatalf("%s: %v", filename, err)
+					}
+					expectedFilename := filepath.Join(dir, className+suffix)
+					if *update {
+						if err := os.WriteFile(expectedFilename, []byte(out), 0644); err != nil {
+							t.Fatal(err)
+						}
+						continue
+					}
+					content, err := os.ReadFile(expectedFilename)
+					if os.IsNotExist(err) {
+						missing = append(missing, filepath.Base(expectedFilename))
+						continue
+					}
+					if err != nil {
+						t.Fatal(err)
+					}
+					// the course files end their lines with \r\n
+					want := strings.ReplaceAll(string(content), "\r\n", "\n")
+					if suffix == ".vm" {
+						expected[className], actual[className] = want, out
+						continue
+					}
+					if line, ok := firstDifference(want, out); !ok {
+						t.Errorf("%s differs from the output at line %d\n%s", expectedFilename, line, showDifference(want, out, line))
+					}
+				}
+			}
+			if *update {
+				return
+			}
+			if len(missing) > 0 {
+				sort.Strings(missing)
+				t.Fatalf("no reference files %s, copy the ones of the nand2tetris course", strings.Join(missing, ", "))
+			}
+			compareRuns(t, dir, expected, actual)
+		})
+	}
+}
+
+func compileFile(filename string, output func(analyzer.File, *strings.Builder) error) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	var out strings.Builder
+	err = output(file, &out)
+	return out.String(), err
+}
+
+// firstDifference returns the first line, counting from 1, where the texts differ.
+func firstDifference(expected, actual string) (int, bool) {
+	if expected == actual {
+		return 0, true
+	}
+	expectedLines, actualLines := strings.Split(expected, "\n"), strings.Split(actual, "\n")
+	for i := range expectedLines {
+		if i >= len(actualLines) || expectedLines[i] != actualLines[i] {
+			return i + 1, false
+		}
+	}
+	return len(expectedLines) + 1, false
+}
+
+func showDifference(expected, actual string, line int) string {
+	at := func(text string) string {
+		lines := strings.Split(text, "\n")
+		if line > len(lines) {
+			return "(end of file)"
+		}
+		return lines[line-1]
+	}
+	return fmt.Sprintf("expected: %s\nactual:   %s", at(expected), at(actual))
+}
+
+// run is what a program did in the vm emulator, the OS functions it called, with their
+// arguments, and what it printed.
+type run struct {
+	calls []string
+	text  string
+	// ended before the step limit
+	ended bool
+}
+
+// compareRuns runs the expected and the actual vm code of a program in the vm emulator, with
+// the keys of the input.txt file of its directory typed ahead, comparing the OS functions
+// called and what the programs printed. Labels and instructions may differ, what the programs
+// do may not. A program stopped at the step limit is compared up to where it got.
+func compareRuns(t *testing.T, dir string, expected, actual map[string]string) {
+	t.Helper()
+	input, err := os.ReadFile(filepath.Join(dir, "input.txt"))
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	expectedRun, err := runProgram(expected, string(input))
+	if err != nil {
+		t.Fatalf("expected vm code: %v", err)
+	}
+	actualRun, err := runProgram(actual, string(input))
+	if err != nil {
+		t.Fatalf("vm code compiled: %v", err)
+	}
+
+	calls := len(expectedRun.calls)
+	if len(actualRun.calls) < calls {
+		calls = len(actualRun.calls)
+	}
+	for i := 0; i < calls; i++ {
+		if expectedRun.calls[i] != actualRun.calls[i] {
+			t.Fatalf("OS call %d differs\nexpected: %s\nactual:   %s", i+1, expectedRun.calls[i], actualRun.calls[i])
+		}
+	}
+	if expectedRun.ended && actualRun.ended {
+		if len(expectedRun.calls) != len(actualRun.calls) {
+			t.Errorf("expected %d OS calls, got %d", len(expectedRun.calls), len(actualRun.calls))
+		}
+		if expectedRun.text != actualRun.text {
+			t.Errorf("expected the output\n%s\ngot\n%s", expectedRun.text, actualRun.text)
+		}
+	}
+}
+
+func runProgram(classes map[string]string, input string) (run, error) {
+	var r run
+	machine := emulator.New()
+	for className, code := range classes {
+		if err := machine.Load(className, code); err != nil {
+			return r, err
+		}
+	}
+	machine.Type(input)
+	machine.OnNative(func(function string, args []int16) {
+		r.calls = append(r.calls, fmt.Sprintf("%s%v", function, args))
+	})
+	if err := machine.Boot(); err != nil {
+		return r, err
+	}
+	for machine.Steps < runMaxSteps && !machine.Halted() {
+		if err := machine.Step(); err != nil {
+			return r, err
+		}
+	}
+	r.ended = machine.Halted()
+	r.text = machine.Text()
+	return r, nil
+}
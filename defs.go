@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+
+	"github.com/hlmerscher/jack-compiler-go/analyzer"
+	"github.com/hlmerscher/jack-compiler-go/engine"
+	"github.com/hlmerscher/jack-compiler-go/jackos"
+)
+
+const defExtension = ".jackdef"
+
+// libraryDefs are the descriptors of the precompiled classes given by the -defs flag, by class name.
+var libraryDefs map[string]engine.ClassDef
+
+// loadDefs reads the class descriptors of a comma separated list of .jackdef files, directories
+// of them, and os, standing for the descriptors of the Jack OS.
+func loadDefs(list string) (map[string]engine.ClassDef, error) {
+	classDefs := make(map[string]engine.ClassDef)
+	for _, name := range splitList(list) {
+		if name == "os" {
+			osDefs, err := jackos.Defs()
+			if err != nil {
+				return nil, err
+			}
+			for className, def := range osDefs {
+				classDefs[className] = def
+			}
+			continue
+		}
+
+		filenames := []string{name}
+		if info, err := os.Stat(name); err != nil {
+			return nil, err
+		} else if info.IsDir() {
+			filenames, err = dirFilenames(name, defExtension, nil, nil)
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, filename := range filenames {
+			def, err := readDef(filename)
+			if err != nil {
+				return nil, err
+			}
+			classDefs[def.Class] = def
+		}
+	}
+	return classDefs, nil
+}
+
+func readDef(filename string) (engine.ClassDef, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return engine.ClassDef{}, err
+	}
+	defer file.Close()
+	return engine.ReadClassDef(file)
+}
+
+// projectDefs reads the descriptors of the classes compiled from their declarations beforehand,
+// by source root, so every call is checked against the sources, the calls within a class
+// included, and the constants are inlined across classes. The classes failing to parse are left
+// out, the error being reported when they are compiled.
+func projectDefs(sources []source) (map[string]map[string]engine.ClassDef, error) {
+	classDefs := make(map[string]map[string]engine.ClassDef)
+	for _, src := range sources {
+		sourceFile, err := src.open()
+		if err != nil {
+			return nil, err
+		}
+		def, err := analyzer.Declarations(sourceFile, opts)
+		sourceFile.Close()
+		if err != nil {
+			continue
+		}
+		if classDefs[src.root] == nil {
+			classDefs[src.root] = make(map[string]engine.ClassDef)
+		}
+		classDefs[src.root][def.Class] = def
+	}
+	return classDefs, nil
+}
+
+// writeDef writes the descriptor of a class next to its vm file.
+func writeDef(outputFilename string, def engine.ClassDef) error {
+	content, err := json.MarshalIndent(def, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeToFile(strings.TrimSuffix(outputFilename, ".vm")+defExtension, string(content)+"\n")
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/hlmerscher/jack-compiler-go/engine"
+	"github.com/hlmerscher/jack-compiler-go/logger"
+)
+
+// formats of the diagnostics
+const (
+	textFormat  = "text"
+	gccFormat   = "gcc"
+	jsonFormat  = "json"
+	sarifFormat = "sarif"
+)
+
+var (
+	diagnosticsFormat = textFormat
+	// diagnostics of the json and sarif formats, written all at once when the command is done
+	collected []*engine.Diagnostic
+)
+
+// report writes the diagnostics of a file, or collects them to be flushed.
+func report(filename string, warnings []*engine.Diagnostic, err error) {
+	diagnostics := warnings[:len(warnings):len(warnings)]
+	if err != nil {
+		diagnostics = append(diagnostics, engine.AsDiagnostic(err, filename))
+	}
+
+	for _, d := range diagnostics {
+		switch {
+		case diagnosticsFormat == jsonFormat || diagnosticsFormat == sarifFormat:
+			collected = append(collected, d)
+		case diagnosticsFormat == gccFormat || compact:
+			fmt.Fprint(os.Stderr, gccDiagnostic(d))
+		default:
+			fmt.Fprint(os.Stderr, humanDiagnostic(d))
+		}
+	}
+}
+
+// flushDiagnostics writes the diagnostics collected to the standard output.
+func flushDiagnostics() {
+	var document any
+	switch diagnosticsFormat {
+	case jsonFormat:
+		document = append([]*engine.Diagnostic{}, collected...)
+	case sarifFormat:
+		document = sarifLog(collected)
+	default:
+		return
+	}
+	collected = nil
+
+	content, err := json.MarshalIndent(document, "", "  ")
+	logger.Errorf("error encoding the diagnostics\n", err)
+	fmt.Println(string(content))
+}
+
+// humanDiagnostic formats a diagnostic for people, like the rust compiler does, quoting the
+// source line with the range underlined, followed by the hints and related locations.
+func humanDiagnostic(d *engine.Diagnostic) string {
+	var out strings.Builder
+	lines := strings.Split(d.Message, "\n")
+	fmt.Fprintf(&out, "%s[%s]: %s\n", d.Severity, d.Code, lines[0])
+
+	lastLine := d.Range.Start.Line
+	for _, related := range d.Related {
+		if related.Range.Start.Line > lastLine {
+			lastLine = related.Range.Start.Line
+		}
+	}
+	gutter := strings.Repeat(" ", len(strconv.Itoa(lastLine)))
+
+	sourceLine := d.SourceLine
+	if sourceLine == "" {
+		sourceLine = readSourceLine(d.File, d.Range.Start.Line)
+	}
+	writeExcerpt(&out, gutter, d.File, sourceLine, d.Range, "^", d.Label)
+	for _, line := range lines[1:] {
+		fmt.Fprintf(&out, "%s = note: %s\n", gutter, line)
+	}
+	for _, hint := range d.Hints {
+		fmt.Fprintf(&out, "%s = help: %s\n", gutter, hint)
+	}
+
+	for _, related := range d.Related {
+		file := related.File
+		if file == "" {
+			file = d.File
+		}
+		fmt.Fprintf(&out, "note: %s\n", related.Message)
+		writeExcerpt(&out, gutter, file, readSourceLine(file, related.Range.Start.Line), related.Range, "-", "")
+	}
+	out.WriteString("\n")
+
+	return out.String()
+}
+
+// writeExcerpt writes the location, and the source line with the range underlined by marks.
+func writeExcerpt(out *strings.Builder, gutter, file, sourceLine string, r engine.Range, mark, label string) {
+	fmt.Fprintf(out, "%s--> %s:%d:%d\n", gutter, file, r.Start.Line, r.Start.Column)
+	if sourceLine == "" {
+		return
+	}
+	width := 1
+	if r.End.Line == r.Start.Line && r.End.Column > r.Start.Column {
+		width = r.End.Column - r.Start.Column
+	}
+	underline := strings.Repeat(" ", r.Start.Column-1) + strings.Repeat(mark, width)
+	if label != "" {
+		underline += " " + label
+	}
+
+	fmt.Fprintf(out, "%s |\n", gutter)
+	fmt.Fprintf(out, "%*d | %s\n", len(gutter), r.Start.Line, strings.ReplaceAll(sourceLine, "\t", " "))
+	fmt.Fprintf(out, "%s | %s\n", gutter, underline)
+}
+
+// sourceLines caches the lines of the files quoted by diagnostics.
+var sourceLines = make(map[string][]string)
+
+func readSourceLine(file string, lineNr int) string {
+	lines, ok := sourceLines[file]
+	if !ok {
+		content, _ := os.ReadFile(file)
+		lines = strings.Split(strings.ReplaceAll(string(content), "\r", ""), "\n")
+		sourceLines[file] = lines
+	}
+	if lineNr < 1 || lineNr > len(lines) {
+		return ""
+	}
+	return lines[lineNr-1]
+}
+
+// gccDiagnostic formats a diagnostic like gcc does, as file:line:column: severity: message,
+// followed by notes for the further lines of the message and the related locations.
+func gccDiagnostic(d *engine.Diagnostic) string {
+	var out strings.Builder
+	position := fmt.Sprintf("%s:%d:%d", d.File, d.Range.Start.Line, d.Range.Start.Column)
+	lines := strings.Split(d.Message, "\n")
+	fmt.Fprintf(&out, "%s: %s: %s [%s]\n", position, d.Severity, lines[0], d.Code)
+	for _, line := range lines[1:] {
+		fmt.Fprintf(&out, "%s: note: %s\n", position, line)
+	}
+	for _, related := range d.Related {
+		file := related.File
+		if file == "" {
+			file = d.File
+		}
+		fmt.Fprintf(&out, "%s:%d:%d: note: %s\n", file, related.Range.Start.Line, related.Range.Start.Column, related.Message)
+	}
+	return out.String()
+}
+
+// sarif 2.1.0 log, as read by code scanning tools, with only the properties the compiler fills in.
+type sarif struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver struct {
+		Name  string      `json:"name"`
+		Rules []sarifRule `json:"rules"`
+	} `json:"driver"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID           string          `json:"ruleId"`
+	Level            string          `json:"level"`
+	Message          sarifMessage    `json:"message"`
+	Locations        []sarifLocation `json:"locations"`
+	RelatedLocations []sarifLocation `json:"relatedLocations,omitempty"`
+}
+
+type sarifLocation struct {
+	ID               *int          `json:"id,omitempty"`
+	PhysicalLocation sarifPhysical `json:"physicalLocation"`
+	Message          *sarifMessage `json:"message,omitempty"`
+}
+
+type sarifPhysical struct {
+	ArtifactLocation struct {
+		URI string `json:"uri"`
+	} `json:"artifactLocation"`
+	Region struct {
+		StartLine   int `json:"startLine"`
+		StartColumn int `json:"startColumn"`
+		EndLine     int `json:"endLine"`
+		EndColumn   int `json:"endColumn"`
+	} `json:"region"`
+}
+
+func sarifLog(diagnostics []*engine.Diagnostic) sarif {
+	run := sarifRun{Results: []sarifResult{}}
+	run.Tool.Driver.Name = "jack"
+	for _, code := range engine.Codes() {
+		run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{
+			ID:               string(code),
+			ShortDescription: sarifMessage{code.Description()},
+		})
+	}
+
+	for _, d := range diagnostics {
+		result := sarifResult{
+			RuleID:    string(d.Code),
+			Level:     string(d.Severity),
+			Message:   sarifMessage{d.Message},
+			Locations: []sarifLocation{{PhysicalLocation: sarifRegion(d.File, d.Range)}},
+		}
+		for i, related := range d.Related {
+			file := related.File
+			if file == "" {
+				file = d.File
+			}
+			id := i
+			result.RelatedLocations = append(result.RelatedLocations, sarifLocation{
+				ID:               &id,
+				PhysicalLocation: sarifRegion(file, related.Range),
+				Message:          &sarifMessage{related.Message},
+			})
+		}
+		run.Results = append(run.Results, result)
+	}
+
+	return sarif{
+		Version: "2.1.0",
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Runs:    []sarifRun{run},
+	}
+}
+
+func sarifRegion(file string, r engine.Range) sarifPhysical {
+	var location sarifPhysical
+	location.ArtifactLocation.URI = filepath.ToSlash(file)
+	location.Region.StartLine = r.Start.Line
+	location.Region.StartColumn = r.Start.Column
+	location.Region.EndLine = r.End.Line
+	location.Region.EndColumn = r.End.Column
+	return location
+}
@@ -0,0 +1,568 @@
+package emulator
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// addresses of the hack platform memory map
+const (
+	SP   = 0
+	LCL  = 1
+	ARG  = 2
+	THIS = 3
+	THAT = 4
+
+	TempBase   = 5
+	StaticBase = 16
+	StackBase  = 256
+	HeapBase   = 2048
+	Screen     = 16384
+	Keyboard   = 24576
+	RAMSize    = 32768
+)
+
+// returning to this address ends the run
+const haltAddress = -1
+
+// returning to this address ends a vm function called by a native one
+const nativeReturn = -2
+
+type instruction struct {
+	command string
+	segment string
+	arg     int
+	// called function, or label jumped to
+	target string
+	// resolved address of the label or function
+	jump int
+	// OS function implemented in Go called, when no vm function of that name is loaded
+	native *native
+	// name of the file and function the instruction is in, for statics and errors
+	file     string
+	function string
+	source   string
+	// line of the instruction in the vm code of the file
+	line int
+}
+
+// frame is a function call in progress.
+type frame struct {
+	function string
+	returnPC int
+}
+
+// Machine runs vm code, like the nand2tetris vm emulator.
+type Machine struct {
+	RAM [RAMSize]int16
+	PC  int
+	// instructions run so far
+	Steps int
+
+	program   []instruction
+	functions map[string]int
+	// static segment base address of each file
+	statics    map[string]int
+	nextStatic int
+	frames     []frame
+	linked     bool
+	halted     bool
+	os         nativeOS
+	// native functions defined for this machine, by name
+	defined map[string]native
+	// called with every native function the program calls, when set
+	nativeCalled func(function string, args []int16)
+}
+
+func New() *Machine {
+	return &Machine{
+		functions:  make(map[string]int),
+		statics:    make(map[string]int),
+		nextStatic: StaticBase,
+		os:         newNativeOS(),
+		defined:    make(map[string]native),
+	}
+}
+
+// Load parses the vm code of a file, named after its class, adding it to the program.
+func (m *Machine) Load(name, code string) error {
+	start := len(m.program)
+	labels := make(map[string]int)
+	var function string
+	maxStatic := -1
+
+	for lineNr, line := range strings.Split(code, "\n") {
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		inst := instruction{command: fields[0], file: name, source: strings.Join(fields, " "), line: lineNr + 1}
+		fail := func(msg string) error {
+			return fmt.Errorf("%s line %d: %q\n%s", name, lineNr+1, inst.source, msg)
+		}
+		switch inst.command {
+		case "push", "pop":
+			if len(fields) != 3 {
+				return fail("expected segment and index")
+			}
+			inst.segment = fields[1]
+			n, err := strconv.Atoi(fields[2])
+			if err != nil || n < 0 {
+				return fail("invalid index")
+			}
+			inst.arg = n
+			if inst.segment == "static" && n > maxStatic {
+				maxStatic = n
+			}
+		case "label":
+			if len(fields) != 2 {
+				return fail("expected label name")
+			}
+			// labels are scoped to their function, and take the address of the next instruction
+			labels[function+"$"+fields[1]] = len(m.program)
+			continue
+		case "goto", "if-goto":
+			if len(fields) != 2 {
+				return fail("expected label name")
+			}
+			inst.target = function + "$" + fields[1]
+		case "function", "call":
+			if len(fields) != 3 {
+				return fail("expected function name and count")
+			}
+			n, err := strconv.Atoi(fields[2])
+			if err != nil || n < 0 {
+				return fail("invalid count")
+			}
+			inst.target, inst.arg = fields[1], n
+			if inst.command == "function" {
+				function = inst.target
+				if _, ok := m.functions[function]; ok {
+					return fail(fmt.Sprintf("function %s already defined", function))
+				}
+				m.functions[function] = len(m.program)
+			}
+		case "add", "sub", "neg", "eq", "gt", "lt", "and", "or", "not", "return":
+		default:
+			return fail("unknown command")
+		}
+		inst.function = function
+		m.program = append(m.program, inst)
+	}
+
+	for i := start; i < len(m.program); i++ {
+		inst := &m.program[i]
+		if inst.command != "goto" && inst.command != "if-goto" {
+			continue
+		}
+		jump, ok := labels[inst.target]
+		if !ok {
+			return fmt.Errorf("%s: %q\nlabel not found in %s", name, inst.source, inst.function)
+		}
+		inst.jump = jump
+	}
+
+	if maxStatic >= 0 {
+		if _, ok := m.statics[name]; !ok {
+			m.statics[name] = m.nextStatic
+			m.nextStatic += maxStatic + 1
+		}
+		if m.nextStatic > StackBase {
+			return fmt.Errorf("%s: static segment overflow, more than %d statics in the program", name, StackBase-StaticBase)
+		}
+	}
+	m.linked = false
+
+	return nil
+}
+
+func (m *Machine) HasFunction(name string) bool {
+	_, ok := m.functions[name]
+	return ok
+}
+
+// link resolves the addresses of the functions called, or the native OS functions of the
+// ones not loaded.
+func (m *Machine) link() error {
+	if m.linked {
+		return nil
+	}
+	for i := range m.program {
+		inst := &m.program[i]
+		if inst.command != "call" {
+			continue
+		}
+		inst.native = nil
+		if address, ok := m.functions[inst.target]; ok {
+			inst.jump = address
+			continue
+		}
+		nat, ok := m.native(inst.target)
+		if !ok {
+			return &RuntimeError{Function: inst.function, Instruction: inst.source, Msg: fmt.Sprintf("function %s not found", inst.target)}
+		}
+		if inst.arg != nat.nArgs {
+			return &RuntimeError{Function: inst.function, Instruction: inst.source, Msg: fmt.Sprintf("function %s takes %d arguments", inst.target, nat.nArgs)}
+		}
+		inst.native = &nat
+	}
+	m.linked = true
+	return nil
+}
+
+// Boot sets the stack up and calls Sys.init, or Main.main when there is no Sys.init,
+// ending the run when it returns.
+func (m *Machine) Boot() error {
+	m.RAM[SP] = StackBase
+	entry := "Sys.init"
+	if !m.HasFunction(entry) {
+		entry = "Main.main"
+	}
+	return m.Call(entry)
+}
+
+// Start points the program counter at Sys.init, or at the first instruction when there is no
+// Sys.init, without calling it, the way the nand2tetris vm emulator loads a program.
+func (m *Machine) Start() error {
+	if err := m.link(); err != nil {
+		return err
+	}
+	m.PC = 0
+	if address, ok := m.functions["Sys.init"]; ok {
+		m.PC = address
+	}
+	m.frames = nil
+	m.halted = len(m.program) == 0
+	return nil
+}
+
+// Call calls the function with the arguments, ending the run when it returns.
+func (m *Machine) Call(function string, args ...int16) error {
+	if err := m.link(); err != nil {
+		return err
+	}
+	address, ok := m.functions[function]
+	if !ok {
+		return fmt.Errorf("function %s not found", function)
+	}
+	if m.RAM[SP] < StackBase {
+		m.RAM[SP] = StackBase
+	}
+	for _, arg := range args {
+		m.push(arg)
+	}
+	m.call(function, len(args), haltAddress)
+	m.PC = address
+	m.halted = false
+
+	return nil
+}
+
+// Run runs until the called function returns, Sys.halt is reached, or after maxSteps
+// instructions when maxSteps is positive.
+func (m *Machine) Run(maxSteps int) error {
+	if err := m.link(); err != nil {
+		return err
+	}
+	for steps := 0; !m.halted; steps++ {
+		if maxSteps > 0 && steps >= maxSteps {
+			return fmt.Errorf("still running after %d steps", maxSteps)
+		}
+		if err := m.Step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Machine) Halted() bool {
+	return m.halted
+}
+
+// Result is the value on top of the stack, what the called function returned once halted.
+func (m *Machine) Result() int16 {
+	return m.RAM[m.RAM[SP]-1]
+}
+
+// Location is where a function being run is at, by the line of the vm code of its file.
+type Location struct {
+	Function string
+	File     string
+	Line     int
+}
+
+// Trace returns where the functions being run are at, the innermost last, at the instruction
+// being run, or the instruction calling the next function.
+func (m *Machine) Trace() []Location {
+	trace := make([]Location, 0, len(m.frames))
+	for i, f := range m.frames {
+		pc := m.PC
+		if i+1 < len(m.frames) {
+			pc = m.frames[i+1].returnPC - 1
+		}
+		location := Location{Function: f.function}
+		if pc >= 0 && pc < len(m.program) {
+			location.File, location.Line = m.program[pc].file, m.program[pc].line
+		}
+		trace = append(trace, location)
+	}
+	return trace
+}
+
+// CallStack returns the functions being run, the innermost last.
+func (m *Machine) CallStack() []string {
+	functions := make([]string, len(m.frames))
+	for i, f := range m.frames {
+		functions[i] = f.function
+	}
+	return functions
+}
+
+// Step runs a single instruction.
+func (m *Machine) Step() (err error) {
+	if m.halted {
+		return nil
+	}
+	if err := m.link(); err != nil {
+		return err
+	}
+	if m.PC < 0 || m.PC >= len(m.program) {
+		m.halted = true
+		return nil
+	}
+
+	inst := &m.program[m.PC]
+	defer func() {
+		if r := recover(); r != nil {
+			msg, ok := r.(memoryError)
+			if !ok {
+				panic(r)
+			}
+			err = &RuntimeError{Function: inst.function, Instruction: inst.source, Msg: string(msg), CallStack: m.CallStack()}
+		}
+	}()
+
+	m.Steps++
+	next := m.PC + 1
+	switch inst.command {
+	case "push":
+		value, err := m.read(inst)
+		if err != nil {
+			return m.fail(inst, err.Error())
+		}
+		m.push(value)
+	case "pop":
+		address, err := m.address(inst)
+		if err != nil {
+			return m.fail(inst, err.Error())
+		}
+		m.store(address, m.pop())
+	case "add":
+		b, a := m.pop(), m.pop()
+		m.push(a + b)
+	case "sub":
+		b, a := m.pop(), m.pop()
+		m.push(a - b)
+	case "neg":
+		m.push(-m.pop())
+	case "not":
+		m.push(^m.pop())
+	case "and":
+		b, a := m.pop(), m.pop()
+		m.push(a & b)
+	case "or":
+		b, a := m.pop(), m.pop()
+		m.push(a | b)
+	case "eq":
+		b, a := m.pop(), m.pop()
+		m.push(boolValue(a == b))
+	case "gt":
+		b, a := m.pop(), m.pop()
+		m.push(boolValue(a > b))
+	case "lt":
+		b, a := m.pop(), m.pop()
+		m.push(boolValue(a < b))
+	case "goto":
+		// a jump to itself is an infinite loop, the way programs halt
+		if inst.jump == m.PC {
+			m.halted = true
+		}
+		next = inst.jump
+	case "if-goto":
+		if m.pop() != 0 {
+			next = inst.jump
+		}
+	case "function":
+		if inst.target == "Sys.halt" {
+			m.halted = true
+			return nil
+		}
+		for i := 0; i < inst.arg; i++ {
+			m.push(0)
+		}
+	case "call":
+		if inst.native != nil {
+			args := make([]int16, inst.arg)
+			for i := len(args) - 1; i >= 0; i-- {
+				args[i] = m.pop()
+			}
+			if m.nativeCalled != nil {
+				m.nativeCalled(inst.target, args)
+			}
+			value, err := inst.native.run(m, args)
+			if err != nil {
+				var runtimeErr *RuntimeError
+				if errors.As(err, &runtimeErr) {
+					return err
+				}
+				return m.fail(inst, err.Error())
+			}
+			m.push(value)
+			break
+		}
+		m.call(inst.target, inst.arg, next)
+		next = inst.jump
+	case "return":
+		next = m.ret()
+		if next == haltAddress {
+			m.halted = true
+		}
+	}
+	m.PC = next
+
+	return nil
+}
+
+func (m *Machine) call(function string, nArgs int, returnPC int) {
+	m.push(int16(returnPC))
+	m.push(m.RAM[LCL])
+	m.push(m.RAM[ARG])
+	m.push(m.RAM[THIS])
+	m.push(m.RAM[THAT])
+	m.RAM[ARG] = m.RAM[SP] - int16(nArgs) - 5
+	m.RAM[LCL] = m.RAM[SP]
+	m.frames = append(m.frames, frame{function: function, returnPC: returnPC})
+}
+
+func (m *Machine) ret() int {
+	frameAddress := m.RAM[LCL]
+	value := m.pop()
+	m.store(int(m.RAM[ARG]), value)
+	m.RAM[SP] = m.RAM[ARG] + 1
+	m.RAM[THAT] = m.load(int(frameAddress) - 1)
+	m.RAM[THIS] = m.load(int(frameAddress) - 2)
+	m.RAM[ARG] = m.load(int(frameAddress) - 3)
+	m.RAM[LCL] = m.load(int(frameAddress) - 4)
+
+	// the return address is kept aside too, as program addresses may not fit in a word
+	returnPC := haltAddress
+	if len(m.frames) > 0 {
+		returnPC = m.frames[len(m.frames)-1].returnPC
+		m.frames = m.frames[:len(m.frames)-1]
+	}
+	return returnPC
+}
+
+func (m *Machine) read(inst *instruction) (int16, error) {
+	if inst.segment == "constant" {
+		if inst.arg > 32767 {
+			return 0, fmt.Errorf("constant %d out of range", inst.arg)
+		}
+		return int16(inst.arg), nil
+	}
+	address, err := m.address(inst)
+	if err != nil {
+		return 0, err
+	}
+	return m.load(address), nil
+}
+
+// address resolves the RAM address of a segment entry.
+func (m *Machine) address(inst *instruction) (int, error) {
+	switch inst.segment {
+	case "local":
+		return int(m.RAM[LCL]) + inst.arg, nil
+	case "argument":
+		return int(m.RAM[ARG]) + inst.arg, nil
+	case "this":
+		return int(m.RAM[THIS]) + inst.arg, nil
+	case "that":
+		return int(m.RAM[THAT]) + inst.arg, nil
+	case "pointer":
+		if inst.arg > 1 {
+			return 0, fmt.Errorf("pointer index %d out of range 0..1", inst.arg)
+		}
+		return THIS + inst.arg, nil
+	case "temp":
+		if inst.arg > 7 {
+			return 0, fmt.Errorf("temp index %d out of range 0..7", inst.arg)
+		}
+		return TempBase + inst.arg, nil
+	case "static":
+		return m.statics[inst.file] + inst.arg, nil
+	}
+	return 0, fmt.Errorf("invalid segment %s", inst.segment)
+}
+
+// memoryError is raised when a RAM address is out of range, and turned into a RuntimeError by Step.
+type memoryError string
+
+func (m *Machine) load(address int) int16 {
+	if address < 0 || address >= RAMSize {
+		panic(memoryError(fmt.Sprintf("address %d out of range", address)))
+	}
+	return m.RAM[address]
+}
+
+func (m *Machine) store(address int, value int16) {
+	if address < 0 || address >= RAMSize {
+		panic(memoryError(fmt.Sprintf("address %d out of range", address)))
+	}
+	m.RAM[address] = value
+}
+
+func (m *Machine) push(value int16) {
+	sp := int(m.RAM[SP])
+	if sp >= HeapBase {
+		panic(memoryError("stack overflow"))
+	}
+	m.store(sp, value)
+	m.RAM[SP]++
+}
+
+func (m *Machine) pop() int16 {
+	m.RAM[SP]--
+	return m.load(int(m.RAM[SP]))
+}
+
+func (m *Machine) fail(inst *instruction, msg string) error {
+	return &RuntimeError{Function: inst.function, Instruction: inst.source, Msg: msg, CallStack: m.CallStack()}
+}
+
+func boolValue(b bool) int16 {
+	if b {
+		return -1
+	}
+	return 0
+}
+
+type RuntimeError struct {
+	Function    string
+	Instruction string
+	Msg         string
+	CallStack   []string
+}
+
+func (e *RuntimeError) Error() string {
+	msg := fmt.Sprintf("in %s: %q\n%s", e.Function, e.Instruction, e.Msg)
+	if len(e.CallStack) > 1 {
+		msg += "\ncalled from " + strings.Join(e.CallStack[:len(e.CallStack)-1], " <- ")
+	}
+	return msg
+}
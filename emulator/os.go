@@ -0,0 +1,740 @@
+package emulator
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// size of the text written by the Output functions, in characters
+const (
+	TextRows    = 23
+	TextColumns = 64
+)
+
+// keys the Jack OS gives their own codes to
+const (
+	NewLineKey   = 128
+	BackSpaceKey = 129
+)
+
+// NativeFunc implements a function in Go, called with its arguments, returning its value.
+type NativeFunc func(m *Machine, args []int16) (int16, error)
+
+// native is a function implemented in Go, called when no vm function of the same name is
+// loaded, like the OS functions of the nand2tetris vm emulator.
+type native struct {
+	nArgs int
+	run   NativeFunc
+}
+
+// nativeOS is the state of the OS functions implemented in Go.
+type nativeOS struct {
+	// free blocks of the heap, sorted by address, and the size of the blocks allocated by address
+	free      []block
+	allocated map[int]int
+	text      [TextRows][TextColumns]byte
+	row, col  int
+	// keys typed ahead, read one at a time, the first one already seen pressed by keyPressed
+	keys    []int16
+	keySeen bool
+	color   bool
+}
+
+type block struct {
+	address, size int
+}
+
+func newNativeOS() nativeOS {
+	os := nativeOS{
+		free:      []block{{HeapBase, Screen - HeapBase}},
+		allocated: make(map[int]int),
+		color:     true,
+	}
+	for i := range os.text {
+		for j := range os.text[i] {
+			os.text[i][j] = ' '
+		}
+	}
+	return os
+}
+
+// descriptions of the error codes of Sys.error, as in the Jack OS
+var osErrors = map[int16]string{
+	1:  "Sys.wait: duration must be positive",
+	2:  "Array.new: array size must be positive",
+	3:  "Math.divide: division by zero",
+	4:  "Math.sqrt: cannot compute square root of a negative number",
+	5:  "Memory.alloc: allocated memory size must be positive",
+	6:  "Memory.alloc: heap overflow",
+	7:  "Screen.drawPixel: illegal pixel coordinates",
+	8:  "Screen.drawLine: illegal line coordinates",
+	9:  "Screen.drawRectangle: illegal rectangle coordinates",
+	12: "Screen.drawCircle: illegal center coordinates",
+	13: "Screen.drawCircle: illegal radius",
+	14: "String.new: maximum length must be non-negative",
+	15: "String.charAt: string index out of bounds",
+	16: "String.setCharAt: string index out of bounds",
+	17: "String.appendChar: string is full",
+	18: "String.eraseLastChar: string is empty",
+	19: "String.setInt: insufficient string capacity",
+	20: "Output.moveCursor: illegal cursor location",
+}
+
+// osError stops the program the way Sys.error does.
+func osError(code int16) error {
+	if description, ok := osErrors[code]; ok {
+		return fmt.Errorf("Sys.error(%d): %s", code, description)
+	}
+	return fmt.Errorf("Sys.error(%d)", code)
+}
+
+func noop(m *Machine, args []int16) (int16, error) {
+	return 0, nil
+}
+
+var natives map[string]native
+
+func init() {
+	natives = map[string]native{
+		"Math.init":     {0, noop},
+		"Math.abs":      {1, mathAbs},
+		"Math.multiply": {2, mathMultiply},
+		"Math.divide":   {2, mathDivide},
+		"Math.min":      {2, mathMin},
+		"Math.max":      {2, mathMax},
+		"Math.sqrt":     {1, mathSqrt},
+
+		"Memory.init":    {0, noop},
+		"Memory.peek":    {1, memoryPeek},
+		"Memory.poke":    {2, memoryPoke},
+		"Memory.alloc":   {1, memoryAlloc},
+		"Memory.deAlloc": {1, memoryDeAlloc},
+
+		"Array.new":     {1, arrayNew},
+		"Array.dispose": {1, arrayDispose},
+
+		"String.new":           {1, stringNew},
+		"String.dispose":       {1, stringDispose},
+		"String.length":        {1, stringLength},
+		"String.charAt":        {2, stringCharAt},
+		"String.setCharAt":     {3, stringSetCharAt},
+		"String.appendChar":    {2, stringAppendChar},
+		"String.eraseLastChar": {1, stringEraseLastChar},
+		"String.intValue":      {1, stringIntValue},
+		"String.setInt":        {2, stringSetInt},
+		"String.backSpace":     {0, constant(BackSpaceKey)},
+		"String.doubleQuote":   {0, constant('"')},
+		"String.newLine":       {0, constant(NewLineKey)},
+
+		"Output.init":        {0, noop},
+		"Output.moveCursor":  {2, outputMoveCursor},
+		"Output.printChar":   {1, outputPrintChar},
+		"Output.printString": {1, outputPrintString},
+		"Output.printInt":    {1, outputPrintInt},
+		"Output.println":     {0, outputPrintln},
+		"Output.backSpace":   {0, outputBackSpace},
+
+		"Screen.init":          {0, noop},
+		"Screen.clearScreen":   {0, screenClear},
+		"Screen.setColor":      {1, screenSetColor},
+		"Screen.drawPixel":     {2, screenDrawPixel},
+		"Screen.drawLine":      {4, screenDrawLine},
+		"Screen.drawRectangle": {4, screenDrawRectangle},
+		"Screen.drawCircle":    {3, screenDrawCircle},
+
+		"Keyboard.init":       {0, noop},
+		"Keyboard.keyPressed": {0, keyboardKeyPressed},
+		"Keyboard.readChar":   {0, keyboardReadChar},
+		"Keyboard.readLine":   {1, keyboardReadLine},
+		"Keyboard.readInt":    {1, keyboardReadInt},
+
+		"Sys.halt":  {0, sysHalt},
+		"Sys.wait":  {1, sysWait},
+		"Sys.error": {1, sysError},
+	}
+}
+
+// Define implements a function in Go for this machine, called when no vm function of that
+// name is loaded, instead of any OS function of that name.
+func (m *Machine) Define(function string, nArgs int, run NativeFunc) {
+	m.defined[function] = native{nArgs, run}
+	m.linked = false
+}
+
+// OnNative has the function called with every native function the program calls, and its
+// arguments, like the OS functions, which tells what the program does.
+func (m *Machine) OnNative(called func(function string, args []int16)) {
+	m.nativeCalled = called
+}
+
+func (m *Machine) native(function string) (native, bool) {
+	if nat, ok := m.defined[function]; ok {
+		return nat, true
+	}
+	nat, ok := natives[function]
+	return nat, ok
+}
+
+// invoke calls a function from a native one, the vm function when loaded or else the native
+// one, returning what it returns.
+func (m *Machine) invoke(function string, args ...int16) (int16, error) {
+	address, ok := m.functions[function]
+	if !ok {
+		nat, ok := m.native(function)
+		if !ok {
+			return 0, fmt.Errorf("function %s not found", function)
+		}
+		return nat.run(m, args)
+	}
+
+	pc := m.PC
+	depth := len(m.frames)
+	for _, arg := range args {
+		m.push(arg)
+	}
+	m.call(function, len(args), nativeReturn)
+	m.PC = address
+	for len(m.frames) > depth && !m.halted {
+		if err := m.Step(); err != nil {
+			return 0, err
+		}
+	}
+	m.PC = pc
+	if m.halted {
+		return 0, nil
+	}
+	return m.pop(), nil
+}
+
+// Text returns what the Output functions wrote, without the blanks ending the rows and the
+// blank rows at the end.
+func (m *Machine) Text() string {
+	rows := make([]string, TextRows)
+	for i, row := range m.os.text {
+		rows[i] = strings.TrimRight(string(row[:]), " ")
+	}
+	for len(rows) > 0 && rows[len(rows)-1] == "" {
+		rows = rows[:len(rows)-1]
+	}
+	return strings.Join(rows, "\n")
+}
+
+// ReadString returns the characters of a String object, through the String functions.
+func (m *Machine) ReadString(s int16) (string, error) {
+	length, err := m.invoke("String.length", s)
+	if err != nil {
+		return "", err
+	}
+	chars := make([]rune, length)
+	for i := range chars {
+		char, err := m.invoke("String.charAt", s, int16(i))
+		if err != nil {
+			return "", err
+		}
+		chars[i] = rune(char)
+	}
+	return string(chars), nil
+}
+
+// Type queues keys for the Keyboard functions to read, new lines typed as the new line key.
+func (m *Machine) Type(keys string) {
+	for _, key := range keys {
+		if key == '\n' {
+			key = NewLineKey
+		}
+		m.os.keys = append(m.os.keys, int16(key))
+	}
+}
+
+func constant(value int16) NativeFunc {
+	return func(m *Machine, args []int16) (int16, error) {
+		return value, nil
+	}
+}
+
+func mathAbs(m *Machine, args []int16) (int16, error) {
+	if args[0] < 0 {
+		return -args[0], nil
+	}
+	return args[0], nil
+}
+
+func mathMultiply(m *Machine, args []int16) (int16, error) {
+	return args[0] * args[1], nil
+}
+
+func mathDivide(m *Machine, args []int16) (int16, error) {
+	if args[1] == 0 {
+		return 0, osError(3)
+	}
+	return args[0] / args[1], nil
+}
+
+func mathMin(m *Machine, args []int16) (int16, error) {
+	if args[0] < args[1] {
+		return args[0], nil
+	}
+	return args[1], nil
+}
+
+func mathMax(m *Machine, args []int16) (int16, error) {
+	if args[0] > args[1] {
+		return args[0], nil
+	}
+	return args[1], nil
+}
+
+func mathSqrt(m *Machine, args []int16) (int16, error) {
+	if args[0] < 0 {
+		return 0, osError(4)
+	}
+	var root int16
+	for (root+1)*(root+1) <= args[0] && root < 181 {
+		root++
+	}
+	return root, nil
+}
+
+func memoryPeek(m *Machine, args []int16) (int16, error) {
+	return m.load(int(args[0])), nil
+}
+
+func memoryPoke(m *Machine, args []int16) (int16, error) {
+	m.store(int(args[0]), args[1])
+	return 0, nil
+}
+
+// memoryAlloc allocates the first free block large enough, from its start.
+func memoryAlloc(m *Machine, args []int16) (int16, error) {
+	size := int(args[0])
+	if size <= 0 {
+		return 0, osError(5)
+	}
+	for i, free := range m.os.free {
+		if free.size < size {
+			continue
+		}
+		if free.size == size {
+			m.os.free = append(m.os.free[:i], m.os.free[i+1:]...)
+		} else {
+			m.os.free[i] = block{free.address + size, free.size - size}
+		}
+		m.os.allocated[free.address] = size
+		return int16(free.address), nil
+	}
+	return 0, osError(6)
+}
+
+// memoryDeAlloc frees the block, merging it with the free blocks next to it.
+func memoryDeAlloc(m *Machine, args []int16) (int16, error) {
+	address := int(args[0])
+	size, ok := m.os.allocated[address]
+	if !ok {
+		return 0, fmt.Errorf("Memory.deAlloc: %d is not an allocated block", address)
+	}
+	delete(m.os.allocated, address)
+
+	i := sort.Search(len(m.os.free), func(i int) bool { return m.os.free[i].address > address })
+	m.os.free = append(m.os.free[:i], append([]block{{address, size}}, m.os.free[i:]...)...)
+	if i+1 < len(m.os.free) && address+size == m.os.free[i+1].address {
+		m.os.free[i].size += m.os.free[i+1].size
+		m.os.free = append(m.os.free[:i+1], m.os.free[i+2:]...)
+	}
+	if i > 0 && m.os.free[i-1].address+m.os.free[i-1].size == address {
+		m.os.free[i-1].size += m.os.free[i].size
+		m.os.free = append(m.os.free[:i], m.os.free[i+1:]...)
+	}
+	return 0, nil
+}
+
+func arrayNew(m *Machine, args []int16) (int16, error) {
+	if args[0] <= 0 {
+		return 0, osError(2)
+	}
+	return m.invoke("Memory.alloc", args[0])
+}
+
+func arrayDispose(m *Machine, args []int16) (int16, error) {
+	return m.invoke("Memory.deAlloc", args[0])
+}
+
+// Strings are blocks of the heap holding their maximum length, their length, and their characters.
+
+func stringNew(m *Machine, args []int16) (int16, error) {
+	maxLength := args[0]
+	if maxLength < 0 {
+		return 0, osError(14)
+	}
+	s, err := m.invoke("Memory.alloc", maxLength+2)
+	if err != nil {
+		return 0, err
+	}
+	m.store(int(s), maxLength)
+	m.store(int(s)+1, 0)
+	return s, nil
+}
+
+func stringDispose(m *Machine, args []int16) (int16, error) {
+	return m.invoke("Memory.deAlloc", args[0])
+}
+
+func stringLength(m *Machine, args []int16) (int16, error) {
+	return m.load(int(args[0]) + 1), nil
+}
+
+func stringCharAt(m *Machine, args []int16) (int16, error) {
+	s, j := int(args[0]), args[1]
+	if j < 0 || j >= m.load(s+1) {
+		return 0, osError(15)
+	}
+	return m.load(s + 2 + int(j)), nil
+}
+
+func stringSetCharAt(m *Machine, args []int16) (int16, error) {
+	s, j := int(args[0]), args[1]
+	if j < 0 || j >= m.load(s+1) {
+		return 0, osError(16)
+	}
+	m.store(s+2+int(j), args[2])
+	return 0, nil
+}
+
+func stringAppendChar(m *Machine, args []int16) (int16, error) {
+	s := int(args[0])
+	length := m.load(s + 1)
+	if length >= m.load(s) {
+		return 0, osError(17)
+	}
+	m.store(s+2+int(length), args[1])
+	m.store(s+1, length+1)
+	return args[0], nil
+}
+
+func stringEraseLastChar(m *Machine, args []int16) (int16, error) {
+	s := int(args[0])
+	length := m.load(s + 1)
+	if length == 0 {
+		return 0, osError(18)
+	}
+	m.store(s+1, length-1)
+	return 0, nil
+}
+
+// stringIntValue reads the leading digits, after an optional minus sign.
+func stringIntValue(m *Machine, args []int16) (int16, error) {
+	s := int(args[0])
+	length := int(m.load(s + 1))
+	var value int16
+	negative := false
+	for i := 0; i < length; i++ {
+		char := m.load(s + 2 + i)
+		if i == 0 && char == '-' {
+			negative = true
+			continue
+		}
+		if char < '0' || char > '9' {
+			break
+		}
+		value = value*10 + char - '0'
+	}
+	if negative {
+		return -value, nil
+	}
+	return value, nil
+}
+
+func stringSetInt(m *Machine, args []int16) (int16, error) {
+	s := int(args[0])
+	digits := strconv.Itoa(int(args[1]))
+	if len(digits) > int(m.load(s)) {
+		return 0, osError(19)
+	}
+	for i, char := range digits {
+		m.store(s+2+i, int16(char))
+	}
+	m.store(s+1, int16(len(digits)))
+	return 0, nil
+}
+
+func outputMoveCursor(m *Machine, args []int16) (int16, error) {
+	row, col := int(args[0]), int(args[1])
+	if row < 0 || row >= TextRows || col < 0 || col >= TextColumns {
+		return 0, osError(20)
+	}
+	m.os.row, m.os.col = row, col
+	return 0, nil
+}
+
+// outputPrintChar writes the character at the cursor, moving it to the next column, or to
+// the next row at the end of a row.
+func outputPrintChar(m *Machine, args []int16) (int16, error) {
+	switch char := args[0]; {
+	case char == NewLineKey:
+		return outputPrintln(m, nil)
+	case char == BackSpaceKey:
+		return outputBackSpace(m, nil)
+	case char < 32 || char > 126:
+		m.os.text[m.os.row][m.os.col] = '?'
+	default:
+		m.os.text[m.os.row][m.os.col] = byte(char)
+	}
+	if m.os.col == TextColumns-1 {
+		return outputPrintln(m, nil)
+	}
+	m.os.col++
+	return 0, nil
+}
+
+func outputPrintString(m *Machine, args []int16) (int16, error) {
+	length, err := m.invoke("String.length", args[0])
+	if err != nil {
+		return 0, err
+	}
+	for i := int16(0); i < length; i++ {
+		char, err := m.invoke("String.charAt", args[0], i)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := m.invoke("Output.printChar", char); err != nil {
+			return 0, err
+		}
+	}
+	return 0, nil
+}
+
+func outputPrintInt(m *Machine, args []int16) (int16, error) {
+	for _, char := range strconv.Itoa(int(args[0])) {
+		if _, err := m.invoke("Output.printChar", int16(char)); err != nil {
+			return 0, err
+		}
+	}
+	return 0, nil
+}
+
+// outputPrintln moves the cursor to the start of the next row, back to the first row after the last.
+func outputPrintln(m *Machine, args []int16) (int16, error) {
+	m.os.col = 0
+	m.os.row = (m.os.row + 1) % TextRows
+	return 0, nil
+}
+
+func outputBackSpace(m *Machine, args []int16) (int16, error) {
+	switch {
+	case m.os.col > 0:
+		m.os.col--
+	case m.os.row > 0:
+		m.os.row--
+		m.os.col = TextColumns - 1
+	}
+	m.os.text[m.os.row][m.os.col] = ' '
+	return 0, nil
+}
+
+func screenClear(m *Machine, args []int16) (int16, error) {
+	for address := Screen; address < Keyboard; address++ {
+		m.RAM[address] = 0
+	}
+	return 0, nil
+}
+
+func screenSetColor(m *Machine, args []int16) (int16, error) {
+	m.os.color = args[0] != 0
+	return 0, nil
+}
+
+const (
+	screenWidth  = 512
+	screenHeight = 256
+)
+
+func onScreen(x, y int16) bool {
+	return x >= 0 && x < screenWidth && y >= 0 && y < screenHeight
+}
+
+func (m *Machine) drawPixel(x, y int) {
+	address := Screen + y*32 + x/16
+	mask := int16(1) << (x % 16)
+	if m.os.color {
+		m.RAM[address] |= mask
+	} else {
+		m.RAM[address] &^= mask
+	}
+}
+
+func screenDrawPixel(m *Machine, args []int16) (int16, error) {
+	if !onScreen(args[0], args[1]) {
+		return 0, osError(7)
+	}
+	m.drawPixel(int(args[0]), int(args[1]))
+	return 0, nil
+}
+
+// screenDrawLine steps along x or y, whichever keeps the line closer to its slope.
+func screenDrawLine(m *Machine, args []int16) (int16, error) {
+	if !onScreen(args[0], args[1]) || !onScreen(args[2], args[3]) {
+		return 0, osError(8)
+	}
+	x, y, x2, y2 := int(args[0]), int(args[1]), int(args[2]), int(args[3])
+	dx, dy := abs(x2-x), -abs(y2-y)
+	stepX, stepY := sign(x2-x), sign(y2-y)
+	diff := dx + dy
+	for {
+		m.drawPixel(x, y)
+		if x == x2 && y == y2 {
+			return 0, nil
+		}
+		if 2*diff >= dy {
+			diff += dy
+			x += stepX
+		}
+		if 2*diff <= dx {
+			diff += dx
+			y += stepY
+		}
+	}
+}
+
+func screenDrawRectangle(m *Machine, args []int16) (int16, error) {
+	x1, y1, x2, y2 := args[0], args[1], args[2], args[3]
+	if !onScreen(x1, y1) || !onScreen(x2, y2) || x1 > x2 || y1 > y2 {
+		return 0, osError(9)
+	}
+	for y := int(y1); y <= int(y2); y++ {
+		for x := int(x1); x <= int(x2); x++ {
+			m.drawPixel(x, y)
+		}
+	}
+	return 0, nil
+}
+
+func screenDrawCircle(m *Machine, args []int16) (int16, error) {
+	x, y, r := int(args[0]), int(args[1]), int(args[2])
+	if !onScreen(args[0], args[1]) {
+		return 0, osError(12)
+	}
+	if r < 0 || r > 181 || x-r < 0 || x+r >= screenWidth || y-r < 0 || y+r >= screenHeight {
+		return 0, osError(13)
+	}
+	for dy := -r; dy <= r; dy++ {
+		half := 0
+		for (half+1)*(half+1) <= r*r-dy*dy {
+			half++
+		}
+		for dx := -half; dx <= half; dx++ {
+			m.drawPixel(x+dx, y+dy)
+		}
+	}
+	return 0, nil
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+// keyboardKeyPressed returns the next key typed ahead, held down until seen, and released on
+// the call after, or else the key in the keyboard memory map.
+func keyboardKeyPressed(m *Machine, args []int16) (int16, error) {
+	if len(m.os.keys) == 0 {
+		return m.RAM[Keyboard], nil
+	}
+	if m.os.keySeen {
+		m.os.keys = m.os.keys[1:]
+		m.os.keySeen = false
+		return 0, nil
+	}
+	m.os.keySeen = true
+	return m.os.keys[0], nil
+}
+
+// keyboardReadChar reads the next key typed ahead, echoing it, there is nobody to wait for.
+func keyboardReadChar(m *Machine, args []int16) (int16, error) {
+	if len(m.os.keys) == 0 {
+		return 0, fmt.Errorf("Keyboard.readChar: no more keys typed")
+	}
+	key := m.os.keys[0]
+	m.os.keys = m.os.keys[1:]
+	m.os.keySeen = false
+	if _, err := m.invoke("Output.printChar", key); err != nil {
+		return 0, err
+	}
+	return key, nil
+}
+
+func keyboardReadLine(m *Machine, args []int16) (int16, error) {
+	if _, err := m.invoke("Output.printString", args[0]); err != nil {
+		return 0, err
+	}
+	var line []int16
+	for {
+		key, err := m.invoke("Keyboard.readChar")
+		if err != nil {
+			return 0, err
+		}
+		if key == NewLineKey {
+			break
+		}
+		if key == BackSpaceKey {
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+			}
+			continue
+		}
+		line = append(line, key)
+	}
+
+	s, err := m.invoke("String.new", int16(len(line)))
+	if err != nil {
+		return 0, err
+	}
+	for _, char := range line {
+		if _, err := m.invoke("String.appendChar", s, char); err != nil {
+			return 0, err
+		}
+	}
+	return s, nil
+}
+
+func keyboardReadInt(m *Machine, args []int16) (int16, error) {
+	s, err := m.invoke("Keyboard.readLine", args[0])
+	if err != nil {
+		return 0, err
+	}
+	value, err := m.invoke("String.intValue", s)
+	if err != nil {
+		return 0, err
+	}
+	_, err = m.invoke("String.dispose", s)
+	return value, err
+}
+
+func sysHalt(m *Machine, args []int16) (int16, error) {
+	m.halted = true
+	return 0, nil
+}
+
+// sysWait returns right away, there is nothing to wait for.
+func sysWait(m *Machine, args []int16) (int16, error) {
+	if args[0] < 0 {
+		return 0, osError(1)
+	}
+	return 0, nil
+}
+
+func sysError(m *Machine, args []int16) (int16, error) {
+	return 0, osError(args[0])
+}
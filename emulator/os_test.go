@@ -0,0 +1,41 @@
+package emulator
+
+import "testing"
+
+func TestKeyPressed(t *testing.T) {
+	m := New()
+	m.Type("ab")
+	// each key is held down until seen, and released on the call after
+	for i, want := range []int16{'a', 0, 'b', 0, 0} {
+		got, err := keyboardKeyPressed(m, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("call %d: keyPressed() = %d, want %d", i+1, got, want)
+		}
+	}
+}
+
+func TestReadCharTakesTheKeySeen(t *testing.T) {
+	m := New()
+	m.Type("xy")
+	if key, _ := keyboardKeyPressed(m, nil); key != 'x' {
+		t.Fatalf("keyPressed() = %d, want %d", key, 'x')
+	}
+	for _, want := range []int16{'x', 'y'} {
+		got, err := keyboardReadChar(m, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("readChar() = %d, want %d", got, want)
+		}
+	}
+	if _, err := keyboardReadChar(m, nil); err == nil {
+		t.Error("readChar() with no keys left, want an error")
+	}
+	if text := m.Text(); text != "xy" {
+		t.Errorf("echoed %q, want %q", text, "xy")
+	}
+}
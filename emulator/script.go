@@ -0,0 +1,544 @@
+package emulator
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Script is a nand2tetris test script, the .tst files the course projects are checked with,
+// like:
+//
+//	load SimpleAdd.vm,
+//	output-file SimpleAdd.out,
+//	compare-to SimpleAdd.cmp,
+//	output-list RAM[0]%D2.6.2 RAM[256]%D2.6.2;
+//	set RAM[0] 256,
+//	repeat 3 {
+//	  vmstep;
+//	}
+//	output;
+type Script struct {
+	filename string
+	commands []scriptCommand
+}
+
+type scriptCommand struct {
+	name string
+	args []string
+	line int
+	// commands repeated by repeat and while
+	body []scriptCommand
+}
+
+// ComparisonError is the first line of the output of a script differing from its compare file.
+type ComparisonError struct {
+	Filename string
+	Line     int
+	Expected string
+	Actual   string
+}
+
+func (e *ComparisonError) Error() string {
+	return fmt.Sprintf("comparison failure at line %d of %s\nexpected: %s\nactual:   %s", e.Line, e.Filename, e.Expected, e.Actual)
+}
+
+// ReadScript parses a test script, whose files are relative to its directory.
+func ReadScript(filename string) (*Script, error) {
+	source, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	p := scriptParser{filename: filename, tokens: scanScript(string(source))}
+	commands, err := p.commands(false)
+	if err != nil {
+		return nil, err
+	}
+	return &Script{filename, commands}, nil
+}
+
+type scriptToken struct {
+	text string
+	line int
+}
+
+// scanScript splits a script into words, quoted strings and the , ; ! { } separators,
+// skipping comments.
+func scanScript(source string) []scriptToken {
+	var tokens []scriptToken
+	line := 1
+	for i := 0; i < len(source); {
+		switch char := source[i]; {
+		case char == '\n':
+			line++
+			i++
+		case unicode.IsSpace(rune(char)):
+			i++
+		case strings.HasPrefix(source[i:], "//"):
+			for i < len(source) && source[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(source[i:], "/*"):
+			end := strings.Index(source[i+2:], "*/")
+			if end < 0 {
+				end = len(source) - i - 4
+			}
+			line += strings.Count(source[i:i+end+4], "\n")
+			i += end + 4
+		case char == '"':
+			end := strings.IndexByte(source[i+1:], '"')
+			if end < 0 {
+				end = len(source) - i - 1
+			}
+			tokens = append(tokens, scriptToken{source[i : i+end+2], line})
+			i += end + 2
+		case strings.IndexByte(",;!{}", char) >= 0:
+			tokens = append(tokens, scriptToken{string(char), line})
+			i++
+		default:
+			start := i
+			for i < len(source) && !unicode.IsSpace(rune(source[i])) && strings.IndexByte(",;!{}\"", source[i]) < 0 &&
+				!strings.HasPrefix(source[i:], "//") && !strings.HasPrefix(source[i:], "/*") {
+				i++
+			}
+			tokens = append(tokens, scriptToken{source[start:i], line})
+		}
+	}
+	return tokens
+}
+
+type scriptParser struct {
+	filename string
+	tokens   []scriptToken
+	pos      int
+}
+
+func (p *scriptParser) errorf(line int, format string, args ...any) error {
+	return fmt.Errorf("%s line %d: %s", p.filename, line, fmt.Sprintf(format, args...))
+}
+
+// commands parses commands up to the end of the script, or of the block.
+func (p *scriptParser) commands(block bool) ([]scriptCommand, error) {
+	var commands []scriptCommand
+	for p.pos < len(p.tokens) {
+		token := p.tokens[p.pos]
+		p.pos++
+		switch token.text {
+		case "}":
+			if !block {
+				return nil, p.errorf(token.line, "} without a block")
+			}
+			return commands, nil
+		case ",", ";", "!", "{":
+			return nil, p.errorf(token.line, "unexpected %s", token.text)
+		}
+
+		command := scriptCommand{name: token.text, line: token.line}
+		for p.pos < len(p.tokens) && strings.IndexByte(",;!{}", p.tokens[p.pos].text[0]) < 0 {
+			command.args = append(command.args, p.tokens[p.pos].text)
+			p.pos++
+		}
+		if p.pos == len(p.tokens) {
+			return nil, p.errorf(token.line, "%s not ended with , or ;", command.name)
+		}
+		end := p.tokens[p.pos]
+		p.pos++
+		if command.name == "repeat" || command.name == "while" {
+			if end.text != "{" {
+				return nil, p.errorf(token.line, "%s without a block", command.name)
+			}
+			body, err := p.commands(true)
+			if err != nil {
+				return nil, err
+			}
+			command.body = body
+		} else if end.text == "{" || end.text == "}" {
+			return nil, p.errorf(end.line, "unexpected %s", end.text)
+		}
+		commands = append(commands, command)
+	}
+	if block {
+		return nil, p.errorf(p.tokens[len(p.tokens)-1].line, "block not closed with }")
+	}
+	return commands, nil
+}
+
+// outputColumn is a value of the output-list, like RAM[256]%D2.6.2, written with padLeft
+// spaces, the value in width characters, and padRight spaces.
+type outputColumn struct {
+	name                     string
+	format                   byte
+	padLeft, width, padRight int
+}
+
+func parseOutputColumn(spec string) (outputColumn, error) {
+	column := outputColumn{name: spec, format: 'D', padLeft: 1, width: 6, padRight: 1}
+	i := strings.IndexByte(spec, '%')
+	if i < 0 {
+		return column, nil
+	}
+	column.name = spec[:i]
+	format := spec[i+1:]
+	if len(format) == 0 || strings.IndexByte("DXBS", format[0]) < 0 {
+		return column, fmt.Errorf("invalid output format %q, expected like %%D1.6.1", spec[i:])
+	}
+	sizes := strings.Split(format[1:], ".")
+	if len(sizes) != 3 {
+		return column, fmt.Errorf("invalid output format %q, expected like %%D1.6.1", spec[i:])
+	}
+	column.format = format[0]
+	for j, size := range []*int{&column.padLeft, &column.width, &column.padRight} {
+		n, err := strconv.Atoi(sizes[j])
+		if err != nil || n < 0 {
+			return column, fmt.Errorf("invalid output format %q, expected like %%D1.6.1", spec[i:])
+		}
+		*size = n
+	}
+	return column, nil
+}
+
+// header is the name of the column centered over it.
+func (c outputColumn) header() string {
+	space := c.padLeft + c.width + c.padRight
+	name := c.name
+	if len(name) > space {
+		name = name[:space]
+	}
+	left := (space - len(name)) / 2
+	return strings.Repeat(" ", left) + name + strings.Repeat(" ", space-left-len(name))
+}
+
+func (c outputColumn) value(value int16) string {
+	var text string
+	switch c.format {
+	case 'X':
+		text = fmt.Sprintf("%04X", uint16(value))
+	case 'B':
+		text = fmt.Sprintf("%016b", uint16(value))
+	default:
+		text = strconv.Itoa(int(value))
+	}
+	switch {
+	case len(text) > c.width && (c.format == 'X' || c.format == 'B'):
+		text = text[len(text)-c.width:]
+	case len(text) > c.width:
+		text = text[:c.width]
+	case c.format == 'S':
+		text += strings.Repeat(" ", c.width-len(text))
+	default:
+		text = strings.Repeat(" ", c.width-len(text)) + text
+	}
+	return strings.Repeat(" ", c.padLeft) + text + strings.Repeat(" ", c.padRight)
+}
+
+// scriptRun is the state of a script being run.
+type scriptRun struct {
+	*Script
+	dir     string
+	machine *Machine
+	columns []outputColumn
+	out     *bufio.Writer
+	outFile *os.File
+	// lines of the compare file, and the number of lines output so far
+	compareFilename string
+	compare         []string
+	lines           int
+}
+
+// Run runs the script on a new machine, writing its output file and comparing it with its
+// compare file, failing with a ComparisonError at the first line differing.
+func (s *Script) Run() error {
+	r := &scriptRun{Script: s, dir: filepath.Dir(s.filename), machine: New()}
+	err := r.run(s.commands)
+	if r.out != nil {
+		if flushErr := r.out.Flush(); err == nil {
+			err = flushErr
+		}
+		if closeErr := r.outFile.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func (r *scriptRun) errorf(command scriptCommand, format string, args ...any) error {
+	return fmt.Errorf("%s line %d: %s", r.filename, command.line, fmt.Sprintf(format, args...))
+}
+
+func (r *scriptRun) run(commands []scriptCommand) error {
+	for _, command := range commands {
+		if err := r.runCommand(command); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *scriptRun) runCommand(command scriptCommand) error {
+	args := command.args
+	switch command.name {
+	case "load":
+		if len(args) > 1 {
+			return r.errorf(command, "load takes a vm file or directory")
+		}
+		name := r.dir
+		if len(args) == 1 {
+			name = filepath.Join(r.dir, args[0])
+		}
+		if err := r.load(name); err != nil {
+			return r.errorf(command, "%v", err)
+		}
+	case "output-file":
+		if len(args) != 1 {
+			return r.errorf(command, "output-file takes a file name")
+		}
+		file, err := os.Create(filepath.Join(r.dir, args[0]))
+		if err != nil {
+			return r.errorf(command, "%v", err)
+		}
+		if r.outFile != nil {
+			r.out.Flush()
+			r.outFile.Close()
+		}
+		r.outFile, r.out = file, bufio.NewWriter(file)
+	case "compare-to":
+		if len(args) != 1 {
+			return r.errorf(command, "compare-to takes a file name")
+		}
+		r.compareFilename = filepath.Join(r.dir, args[0])
+		content, err := os.ReadFile(r.compareFilename)
+		if err != nil {
+			return r.errorf(command, "%v", err)
+		}
+		r.compare = strings.Split(strings.TrimRight(strings.ReplaceAll(string(content), "\r", ""), "\n"), "\n")
+	case "output-list":
+		r.columns = nil
+		for _, arg := range args {
+			column, err := parseOutputColumn(arg)
+			if err != nil {
+				return r.errorf(command, "%v", err)
+			}
+			if _, err := r.machine.scriptAddress(column.name); err != nil {
+				return r.errorf(command, "%v", err)
+			}
+			r.columns = append(r.columns, column)
+		}
+		line := "|"
+		for _, column := range r.columns {
+			line += column.header() + "|"
+		}
+		return r.output(line)
+	case "output":
+		line := "|"
+		for _, column := range r.columns {
+			address, _ := r.machine.scriptAddress(column.name)
+			line += column.value(r.machine.RAM[address]) + "|"
+		}
+		return r.output(line)
+	case "set":
+		if len(args) != 2 {
+			return r.errorf(command, "set takes a variable and a value")
+		}
+		address, err := r.machine.scriptAddress(args[0])
+		if err != nil {
+			return r.errorf(command, "%v", err)
+		}
+		value, err := scriptValue(args[1])
+		if err != nil {
+			return r.errorf(command, "%v", err)
+		}
+		r.machine.RAM[address] = value
+	case "vmstep":
+		if err := r.machine.Step(); err != nil {
+			return r.errorf(command, "%v", err)
+		}
+	case "repeat":
+		if len(args) != 1 {
+			return r.errorf(command, "repeat takes a count")
+		}
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 0 {
+			return r.errorf(command, "invalid repeat count %q", args[0])
+		}
+		for i := 0; i < n; i++ {
+			if err := r.run(command.body); err != nil {
+				return err
+			}
+		}
+	case "while":
+		for {
+			ok, err := r.condition(args)
+			if err != nil {
+				return r.errorf(command, "%v", err)
+			}
+			if !ok {
+				break
+			}
+			if err := r.run(command.body); err != nil {
+				return err
+			}
+		}
+	// the interactive commands of the emulator do nothing in a run
+	case "echo", "clear-echo", "breakpoint", "clear-breakpoints":
+	default:
+		return r.errorf(command, "unsupported command %s", command.name)
+	}
+	return nil
+}
+
+// load loads the vm file, or the vm files of the directory, into a new machine.
+func (r *scriptRun) load(name string) error {
+	info, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	filenames := []string{name}
+	if info.IsDir() {
+		if filenames, err = filepath.Glob(filepath.Join(name, "*.vm")); err != nil {
+			return err
+		}
+		if len(filenames) == 0 {
+			return fmt.Errorf("no vm files in %s", name)
+		}
+	}
+	r.machine = New()
+	for _, filename := range filenames {
+		code, err := os.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+		if err := r.machine.Load(strings.TrimSuffix(filepath.Base(filename), ".vm"), string(code)); err != nil {
+			return err
+		}
+	}
+	return r.machine.Start()
+}
+
+// output writes a line of the output file, comparing it with the line of the compare file.
+func (r *scriptRun) output(line string) error {
+	if r.out != nil {
+		fmt.Fprintln(r.out, line)
+	}
+	r.lines++
+	if r.compare == nil {
+		return nil
+	}
+	expected := ""
+	if r.lines <= len(r.compare) {
+		expected = r.compare[r.lines-1]
+	}
+	if !compareLine(line, expected) {
+		return &ComparisonError{r.compareFilename, r.lines, expected, line}
+	}
+	return nil
+}
+
+// compareLine compares the lines the way the nand2tetris tools do, where a * of the
+// expected line matches any character, ignoring trailing spaces.
+func compareLine(actual, expected string) bool {
+	actual, expected = strings.TrimRight(actual, " \t"), strings.TrimRight(expected, " \t")
+	if len(actual) != len(expected) {
+		return false
+	}
+	for i := range expected {
+		if expected[i] != '*' && expected[i] != actual[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// condition evaluates a while condition, like RAM[0] <> 0.
+func (r *scriptRun) condition(args []string) (bool, error) {
+	if len(args) != 3 {
+		return false, fmt.Errorf("while takes a condition, like RAM[0] <> 0")
+	}
+	values := make([]int16, 2)
+	for i, arg := range []string{args[0], args[2]} {
+		if address, err := r.machine.scriptAddress(arg); err == nil {
+			values[i] = r.machine.RAM[address]
+			continue
+		}
+		value, err := scriptValue(arg)
+		if err != nil {
+			return false, err
+		}
+		values[i] = value
+	}
+	a, b := values[0], values[1]
+	switch args[1] {
+	case "=":
+		return a == b, nil
+	case "<>":
+		return a != b, nil
+	case "<":
+		return a < b, nil
+	case ">":
+		return a > b, nil
+	case "<=":
+		return a <= b, nil
+	case ">=":
+		return a >= b, nil
+	}
+	return false, fmt.Errorf("unknown comparison %s", args[1])
+}
+
+// scriptValue parses a decimal value, or one in the %D, %X or %B formats.
+func scriptValue(text string) (int16, error) {
+	base, digits := 10, text
+	if len(text) > 2 && text[0] == '%' {
+		switch text[1] {
+		case 'D':
+		case 'X':
+			base = 16
+		case 'B':
+			base = 2
+		default:
+			return 0, fmt.Errorf("invalid value %s", text)
+		}
+		digits = text[2:]
+	}
+	value, err := strconv.ParseInt(digits, base, 32)
+	if err != nil || value < -32768 || value > 65535 {
+		return 0, fmt.Errorf("invalid value %s", text)
+	}
+	return int16(value), nil
+}
+
+// scriptAddress resolves a variable of the test scripts of the vm emulator, the sp, local,
+// argument, this and that registers, the entries of the segments, like local[2] and temp[0],
+// and RAM[n], to its RAM address.
+func (m *Machine) scriptAddress(name string) (int, error) {
+	segment, index := name, -1
+	if open := strings.IndexByte(name, '['); open > 0 && strings.HasSuffix(name, "]") {
+		n, err := strconv.Atoi(name[open+1 : len(name)-1])
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid index in %s", name)
+		}
+		segment, index = name[:open], n
+	}
+	registers := map[string]int{"sp": SP, "local": LCL, "argument": ARG, "this": THIS, "that": THAT}
+	var address int
+	switch register, ok := registers[segment]; {
+	case ok && index < 0:
+		return register, nil
+	case ok && segment != "sp":
+		address = int(m.RAM[register]) + index
+	case segment == "RAM" && index >= 0:
+		address = index
+	case segment == "temp" && index >= 0 && index < 8:
+		address = TempBase + index
+	case segment == "pointer" && index >= 0 && index < 2:
+		address = THIS + index
+	default:
+		return 0, fmt.Errorf("unknown variable %s", name)
+	}
+	if address < 0 || address >= RAMSize {
+		return 0, fmt.Errorf("%s: address %d out of range", name, address)
+	}
+	return address, nil
+}
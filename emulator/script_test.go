@@ -0,0 +1,133 @@
+package emulator
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScripts(t *testing.T) {
+	tests := []struct {
+		name string
+		// the line of the compare file differing, 0 when the script passes
+		failingLine int
+	}{
+		{"SimpleAdd", 0},
+		{"SumLoop", 0},
+		{"Mismatch", 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// the scripts write their output files next to them
+			dir := copyDir(t, filepath.Join("testdata", test.name))
+			script, err := ReadScript(filepath.Join(dir, test.name+".tst"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = script.Run()
+			var comparisonErr *ComparisonError
+			switch {
+			case test.failingLine == 0 && err != nil:
+				t.Fatal(err)
+			case test.failingLine == 0:
+			case !errors.As(err, &comparisonErr):
+				t.Fatalf("got %v, want a comparison failure", err)
+			case comparisonErr.Line != test.failingLine:
+				t.Errorf("comparison failure at line %d, want %d", comparisonErr.Line, test.failingLine)
+			}
+		})
+	}
+}
+
+func TestScriptErrors(t *testing.T) {
+	tests := []struct {
+		name, script string
+	}{
+		{"unsupported command", "load SimpleAdd.vm,\nticktock;\n"},
+		{"unknown variable", "load SimpleAdd.vm,\nset RAM 1;\n"},
+		{"invalid value", "load SimpleAdd.vm,\nset RAM[0] 99999;\n"},
+		{"missing vm file", "load Nope.vm;\n"},
+		{"invalid output format", "load SimpleAdd.vm,\noutput-list RAM[0]%Q1.6.1;\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := copyDir(t, filepath.Join("testdata", "SimpleAdd"))
+			filename := filepath.Join(dir, "Error.tst")
+			if err := os.WriteFile(filename, []byte(test.script), 0666); err != nil {
+				t.Fatal(err)
+			}
+			script, err := ReadScript(filename)
+			if err == nil {
+				err = script.Run()
+			}
+			if err == nil {
+				t.Error("the script ran, want an error")
+			}
+		})
+	}
+}
+
+func TestCompareLine(t *testing.T) {
+	tests := []struct {
+		actual, expected string
+		want             bool
+	}{
+		{"|   15 |", "|   15 |", true},
+		{"|   15 |", "|   16 |", false},
+		{"|   15 |", "|   ** |", true},
+		{"|   15 |  ", "|   15 |", true},
+		{"|   15 |", "|  15 |", false},
+	}
+	for _, test := range tests {
+		if got := compareLine(test.actual, test.expected); got != test.want {
+			t.Errorf("compareLine(%q, %q) = %v, want %v", test.actual, test.expected, got, test.want)
+		}
+	}
+}
+
+func TestOutputColumn(t *testing.T) {
+	tests := []struct {
+		spec         string
+		value        int16
+		header, text string
+	}{
+		{"RAM[0]%D2.6.2", 257, "  RAM[0]  ", "     257  "},
+		{"RAM[0]", -1, " RAM[0] ", "     -1 "},
+		{"RAM[0]%X1.4.1", -1, "RAM[0]", " FFFF "},
+		{"RAM[0]%B1.8.1", 5, "  RAM[0]  ", " 00000101 "},
+		{"argument[0]%D1.6.1", 3, "argument", "      3 "},
+	}
+	for _, test := range tests {
+		column, err := parseOutputColumn(test.spec)
+		if err != nil {
+			t.Fatalf("%s: %v", test.spec, err)
+		}
+		if header := column.header(); header != test.header {
+			t.Errorf("%s header %q, want %q", test.spec, header, test.header)
+		}
+		if text := column.value(test.value); text != test.text {
+			t.Errorf("%s value %q, want %q", test.spec, text, test.text)
+		}
+	}
+}
+
+// copyDir copies the files of a directory into a temporary one.
+func copyDir(t *testing.T, dir string) string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tempDir := t.TempDir()
+	for _, entry := range entries {
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(tempDir, entry.Name()), content, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return tempDir
+}
@@ -0,0 +1,13 @@
+// pushes two constants and adds them, leaving the sum on top of the stack
+
+load Mismatch.vm,
+compare-to Mismatch.cmp,
+output-list RAM[0]%D2.6.2 RAM[256]%D2.6.2;
+
+set RAM[0] 256,
+
+repeat 3 {
+  vmstep;
+}
+
+output;
@@ -0,0 +1,14 @@
+// pushes two constants and adds them, leaving the sum on top of the stack
+
+load SimpleAdd.vm,
+output-file SimpleAdd.out,
+compare-to SimpleAdd.cmp,
+output-list RAM[0]%D2.6.2 RAM[256]%D2.6.2;
+
+set RAM[0] 256,
+
+repeat 3 {
+  vmstep;
+}
+
+output;
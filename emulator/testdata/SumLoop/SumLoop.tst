@@ -0,0 +1,20 @@
+load SumLoop.vm,
+compare-to SumLoop.cmp,
+output-list RAM[0]%D1.6.1 local[0]%D1.6.1 argument[0]%X1.4.1;
+
+set sp 256,
+set local 300,
+set argument 400,
+set argument[0] 4,
+
+// until the last number is summed
+while argument[0] <> 0 {
+  vmstep;
+}
+
+// the last if-goto falls through to push the sum
+repeat 3 {
+  vmstep;
+}
+
+output;
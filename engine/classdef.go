@@ -0,0 +1,145 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/hlmerscher/jack-compiler-go/symbols"
+	"github.com/hlmerscher/jack-compiler-go/tokenizer"
+)
+
+// ClassDef describes what other classes can use of a compiled class, so calls into it can be
+// checked without its source, like the ones into a precompiled library or the OS.
+// It is written as json to a .jackdef file next to the vm file of the class.
+type ClassDef struct {
+	Class       string              `json:"class"`
+	Fields      int                 `json:"fields"`
+	Statics     []Variable          `json:"statics"`
+	Constants   map[string]Constant `json:"constants,omitempty"`
+	Subroutines []Signature         `json:"subroutines"`
+}
+
+type Variable struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// ClassDef returns the descriptor of the class compiled.
+func (c *Compiler) ClassDef() ClassDef {
+	def := ClassDef{
+		Class:       c.symbols.ClassName(),
+		Fields:      c.symbols.VarCount(symbols.Field),
+		Statics:     make([]Variable, 0),
+		Subroutines: c.signatures,
+	}
+	for _, symbol := range c.symbols.Class().Symbols() {
+		if symbol.Kind == symbols.Static {
+			def.Statics = append(def.Statics, Variable{symbol.Name, symbol.Type})
+		}
+	}
+	if constants := c.Constants(); len(constants) > 0 {
+		def.Constants = constants
+	}
+	if def.Subroutines == nil {
+		def.Subroutines = make([]Signature, 0)
+	}
+	return def
+}
+
+// ReadClassDef reads a class descriptor written as json.
+func ReadClassDef(r io.Reader) (ClassDef, error) {
+	var def ClassDef
+	if err := json.NewDecoder(r).Decode(&def); err != nil {
+		return def, err
+	}
+	if def.Class == "" {
+		return def, fmt.Errorf("class descriptor without a class name")
+	}
+	return def, nil
+}
+
+func (d ClassDef) subroutine(name string) (Signature, bool) {
+	for _, signature := range d.Subroutines {
+		if signature.Name == name {
+			return signature, true
+		}
+	}
+	return Signature{}, false
+}
+
+// checkCall checks a call into a class known by its descriptor, the class compiled included,
+// returning the type the subroutine returns, or no type when the class has no descriptor.
+func (c *Compiler) checkCall(tk *tokenizer.Tokenizer, className string, nameToken tokenizer.Token, onObject bool, argTypes []string) (string, error) {
+	def, ok := c.opts.Defs[className]
+	if !ok {
+		return "", nil
+	}
+	name := className + "." + nameToken.Raw
+
+	signature, ok := def.subroutine(nameToken.Raw)
+	if !ok {
+		names := make([]string, len(def.Subroutines))
+		for i, subroutine := range def.Subroutines {
+			names[i] = subroutine.Name
+		}
+		return "", errorAt(tk, nameToken, UndeclaredSubroutine, "subroutine %s not declared", name).
+			label("not declared in class %s", className).
+			didYouMean(nameToken.Raw, names)
+	}
+	if onObject && signature.Kind != "method" {
+		return "", errorAt(tk, nameToken, InvalidCall, "%s %s called on an object", signature.Kind, name).
+			hint("call it on the class, as %s", name)
+	}
+	if !onObject && signature.Kind == "method" {
+		return "", errorAt(tk, nameToken, InvalidCall, "method %s called on the class, there is no this object", name).
+			hint("call it on a %s object, as obj.%s", className, nameToken.Raw)
+	}
+	if err := c.checkArguments(tk, nameToken, name, signature, argTypes); err != nil {
+		return "", err
+	}
+
+	if signature.ReturnType == "void" {
+		return "", nil
+	}
+	return signature.ReturnType, nil
+}
+
+// checkArguments checks the number of arguments of a call, and warns about the ones of the
+// wrong type.
+func (c *Compiler) checkArguments(tk *tokenizer.Tokenizer, nameToken tokenizer.Token, name string, signature Signature, argTypes []string) error {
+	if len(argTypes) != len(signature.Params) {
+		return errorAt(tk, nameToken, WrongArgumentCount, "%s takes %s, got %d", name, arguments(len(signature.Params)), len(argTypes)).
+			label("expected (%s)", strings.Join(signature.Params, ", "))
+	}
+	for i, argType := range argTypes {
+		if !assignable(argType, signature.Params[i]) {
+			c.warnings = append(c.warnings, warningAt(tk, nameToken, ArgumentType,
+				"argument %d of %s is %s, expected %s", i+1, name, argType, signature.Params[i]).
+				label("expected (%s)", strings.Join(signature.Params, ", ")))
+		}
+	}
+	return nil
+}
+
+// assignable tells whether a value of the type can be passed for a parameter of the other type.
+// Values of unknown type are, Jack converts freely between int, char and boolean, and Array
+// stands for any object.
+func assignable(valueType, paramType string) bool {
+	if valueType == "" || valueType == paramType || valueType == "Array" || paramType == "Array" {
+		return true
+	}
+	return isPrimitive(valueType) && isPrimitive(paramType)
+}
+
+func isPrimitive(typeName string) bool {
+	return typeName == "int" || typeName == "char" || typeName == "boolean"
+}
+
+func arguments(n int) string {
+	if n == 1 {
+		return "1 argument"
+	}
+	return fmt.Sprintf("%d arguments", n)
+}
@@ -4,70 +4,241 @@ import (
 	"errors"
 	"fmt"
 	"regexp"
+	"strings"
 
-	"github.com/hlmerscher/jack-compiler-go/logger"
+	"github.com/hlmerscher/jack-compiler-go/symbols"
 	"github.com/hlmerscher/jack-compiler-go/tokenizer"
 	"github.com/hlmerscher/jack-compiler-go/vm"
+	"golang.org/x/exp/slices"
 )
 
 type Compiler struct {
-	vmw *vm.Writer
+	vmw  *vm.Writer
+	opts Options
 
-	classSymbolTable      map[string]*tokenizer.Var
-	subroutineSymbolTable map[string]*tokenizer.Var
+	symbols *symbols.Table
+
+	// subroutine kinds (constructor, function, method) declared in the class by name
+	subroutines    map[string]string
+	subroutineKind string
+	// where the subroutines are declared, for diagnostics
+	subroutineTokens map[string]tokenizer.Token
+	unqualified      []unqualifiedCall
+
+	warnings []*Diagnostic
+
+	signatures []Signature
+	references map[string]bool
+
+	// parse tree written along the vm code, when requested
+	tree *syntaxTree
+
+	// distinct string constants of the class, stored in statics after the declared ones
+	interned      [][]int
+	internedIndex map[string]int
+	staticCount   int
+}
+
+// name of the generated routine initializing the interned strings, $ keeps it apart from jack subroutines
+const stringsInitRoutine = "$strings"
+
+// unqualifiedCall is a call like foo() that can only be checked once the whole class is read,
+// given the subroutine may be declared after the call site.
+type unqualifiedCall struct {
+	name       string
+	callerKind string
+	token      tokenizer.Token
+	argTypes   []string
 }
 
 func (c *Compiler) Class(tk *tokenizer.Tokenizer) error {
-	c.classSymbolTable = make(map[string]*tokenizer.Var)
+	if c.tree != nil {
+		tk.Consumed = c.tree.token
+	}
+	c.open("class")
+	defer c.close()
+
+	classNameToken, err := c.classDec(tk)
+	if err != nil {
+		return err
+	}
+	for {
+		err := c.Subroutine(tk, classNameToken)
+		if errors.Is(err, notSubroutineDec) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if _, ok := is("}")(tk.Current); !ok {
+		return c.classBodyError(tk)
+	}
+	processTokenOrPanics(tk, is("}"))
+	c.writeStringsInit(classNameToken.Raw)
+
+	return c.checkUnqualifiedCalls(tk)
+}
+
+// ClassDeclarations reads the declarations of a class, skipping the bodies of its subroutines,
+// returning its descriptor, so the calls into the class can be checked before it is compiled.
+func (c *Compiler) ClassDeclarations(tk *tokenizer.Tokenizer) (ClassDef, error) {
+	classNameToken, err := c.classDec(tk)
+	if err != nil {
+		return ClassDef{}, err
+	}
+	for {
+		if _, ok := or(is("constructor"), is("function"), is("method"))(tk.Current); !ok {
+			break
+		}
+		if _, _, err := c.subroutineDec(tk, classNameToken); err != nil {
+			return ClassDef{}, err
+		}
+		if err := skipBlock(tk); err != nil {
+			return ClassDef{}, err
+		}
+	}
+
+	return c.ClassDef(), nil
+}
+
+// skipBlock skips the tokens from { to its matching }.
+func skipBlock(tk *tokenizer.Tokenizer) error {
+	processTokenOrPanics(tk, is("{"))
+	for depth := 1; depth > 0; {
+		token := tk.Current
+		if token.Type == "" {
+			return errorAt(tk, token, UnexpectedToken, "expected \"}\", found the end of the file").
+				label("subroutine not closed")
+		}
+		if token.Type == tokenizer.SYMBOL && token.Raw == "{" {
+			depth++
+		}
+		if token.Type == tokenizer.SYMBOL && token.Raw == "}" {
+			depth--
+		}
+		if _, err := tk.Advance(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Constants returns the constants declared in the class, by name.
+func (c *Compiler) Constants() map[string]Constant {
+	constants := make(map[string]Constant)
+	for _, symbol := range c.symbols.Class().Symbols() {
+		if symbol.Kind == symbols.Const {
+			constants[symbol.Name] = Constant{symbol.Type, symbol.Value}
+		}
+	}
+	return constants
+}
+
+func (c *Compiler) ClassName() string {
+	return c.symbols.ClassName()
+}
+
+// Symbols returns the symbol table of the class, with the scopes of the subroutines compiled.
+func (c *Compiler) Symbols() *symbols.Table {
+	return c.symbols
+}
+
+// classDec compiles the class header and its variable declarations.
+func (c *Compiler) classDec(tk *tokenizer.Tokenizer) (*tokenizer.Token, error) {
+	c.subroutines = make(map[string]string)
+	c.subroutineTokens = make(map[string]tokenizer.Token)
+	c.unqualified = nil
+	c.interned = nil
+	c.internedIndex = make(map[string]int)
+	c.signatures = nil
+	c.references = make(map[string]bool)
 
 	processTokenOrPanics(tk, is("class"))
 	classNameToken := processTokenOrPanics(tk, isIdentifier())
-	c.classSymbolTable["this"] = &tokenizer.Var{
-		Index: 0,
-		Type:  classNameToken.Raw,
-		Kind:  "class",
-	}
+	c.symbols.StartClass(classNameToken.Raw)
 
 	processTokenOrPanics(tk, is("{"))
-	var nvars int
 	for {
-		err := c.ClassVarDec(tk, &nvars)
+		err := c.ClassVarDec(tk)
 		if errors.Is(err, notClassVarDec) {
 			break
 		}
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
-	for {
-		err := c.Subroutine(tk, classNameToken, nvars)
-		if errors.Is(err, notSubroutineDec) {
-			break
+	c.staticCount = c.symbols.VarCount(symbols.Static)
+
+	return classNameToken, nil
+}
+
+// classBodyError reports a token that starts no declaration within the class body.
+func (c *Compiler) classBodyError(tk *tokenizer.Tokenizer) error {
+	token := tk.Current
+	d := errorAt(tk, token, UnexpectedToken, "expected a subroutine declaration or \"}\", found %s", describe(token)).
+		label("not a subroutine declaration")
+
+	if token.Type == "" {
+		return d.label("class not closed").hint("add \"}\" at the end of the class")
+	}
+	if _, ok := or(is("static"), is("field"), is("const"))(token); ok {
+		return d.hint("class variables and constants are declared before the subroutines")
+	}
+	return d.didYouMean(token.Raw, []string{"constructor", "function", "method", "static", "field"})
+}
+
+func (c *Compiler) checkUnqualifiedCalls(tk *tokenizer.Tokenizer) error {
+	for _, call := range c.unqualified {
+		kind, declared := c.subroutines[call.name]
+		if !declared {
+			names := make([]string, 0, len(c.subroutines))
+			for name := range c.subroutines {
+				names = append(names, name)
+			}
+			return errorAt(tk, call.token, UndeclaredSubroutine, "subroutine %q not declared", call.name).
+				label("not declared in class %s", c.symbols.ClassName()).
+				didYouMean(call.name, names)
+		}
+		declaration := c.subroutineTokens[call.name]
+		if kind != "method" {
+			className := c.symbols.ClassName()
+			return errorAt(tk, call.token, InvalidCall, "%s %q must be called as %s.%s", kind, call.name, className, call.name).
+				related(tk, declaration, "%s %s declared here", kind, call.name)
+		}
+		if call.callerKind == "function" {
+			return errorAt(tk, call.token, NoThisObject, "method %q called from a function, there is no this object", call.name).
+				related(tk, declaration, "method %s declared here", call.name)
+		}
+		signature, _ := ClassDef{Subroutines: c.signatures}.subroutine(call.name)
+		if err := c.checkArguments(tk, call.token, c.symbols.ClassName()+"."+call.name, signature, call.argTypes); err != nil {
+			return err
 		}
-		logger.Error(err)
 	}
-	processTokenOrPanics(tk, is("}"))
 
 	return nil
 }
 
-func (c *Compiler) ClassVarDec(tk *tokenizer.Tokenizer, nvars *int) error {
+func (c *Compiler) ClassVarDec(tk *tokenizer.Tokenizer) error {
+	if _, ok := is("const")(tk.Current); ok && c.opts.Has(Constants) {
+		return c.ConstDec(tk)
+	}
+
 	matcher := or(is("static"), is("field"))
 	if _, ok := matcher(tk.Current); !ok {
 		return notClassVarDec
 	}
+	c.open("classVarDec")
+	defer c.close()
 
 	classVarDecToken := processTokenOrPanics(tk, matcher)
 	typeToken := processTokenOrPanics(tk, isType())
 
-	for i := 0; ; i++ {
+	for {
 		varNameToken := processTokenOrPanics(tk, isIdentifier())
-		c.classSymbolTable[varNameToken.Raw] = &tokenizer.Var{
-			Type:  typeToken.Raw,
-			Kind:  classVarDecToken.Raw,
-			Index: *nvars,
+		if err := c.define(tk, varNameToken, typeToken.Raw, symbols.Kind(classVarDecToken.Raw), 0); err != nil {
+			return err
 		}
-		*nvars++
 
 		if _, err := processToken(tk, is(",")); err != nil {
 			break
@@ -78,32 +249,126 @@ func (c *Compiler) ClassVarDec(tk *tokenizer.Tokenizer, nvars *int) error {
 	return nil
 }
 
-func (c *Compiler) Subroutine(tk *tokenizer.Tokenizer, classToken *tokenizer.Token, nClassVars int) error {
-	c.subroutineSymbolTable = make(map[string]*tokenizer.Var)
+// ConstDec compiles const type name = constant;, declaring a constant inlined wherever it is used.
+func (c *Compiler) ConstDec(tk *tokenizer.Tokenizer) error {
+	c.open("constDec")
+	defer c.close()
+
+	processTokenOrPanics(tk, is("const"))
+	typeToken := processTokenOrPanics(tk, isType())
+	nameToken := processTokenOrPanics(tk, isIdentifier())
+	processTokenOrPanics(tk, is("="))
+
+	value, err := constValue(tk, typeToken, nameToken)
+	if err != nil {
+		return err
+	}
+	processTokenOrPanics(tk, is(";"))
+
+	return c.define(tk, nameToken, typeToken.Raw, symbols.Const, value)
+}
+
+// constValue compiles the literal a constant is declared with, of the type declared: an integer
+// constant, negative or not, or a char literal for an int, a char literal or a character code
+// for a char, and true or false for a boolean.
+func constValue(tk *tokenizer.Tokenizer, typeToken, nameToken *tokenizer.Token) (int, error) {
+	switch typeToken.Raw {
+	case "int", "char", "boolean":
+	default:
+		return 0, errorAt(tk, *typeToken, InvalidLiteral, "constant %q is declared %s, constants are int, char or boolean", nameToken.Raw, typeToken.Raw).
+			label("not int, char or boolean")
+	}
+
+	_, negative := is("-")(tk.Current)
+	if negative {
+		processTokenOrPanics(tk, is("-"))
+	}
+	token := tk.Current
+	var value int
+	var valueType string
+	var err error
+	switch {
+	case token.Type == tokenizer.INT_CONST:
+		value, err = intConstant(token.Raw)
+		valueType = "int"
+	case token.Type == tokenizer.CHAR_CONST:
+		value, err = charLiteral(token.Raw)
+		valueType = "char"
+	case token.Raw == "true" || token.Raw == "false":
+		if token.Raw == "true" {
+			value = -1
+		}
+		valueType = "boolean"
+	default:
+		return 0, errorAt(tk, token, InvalidLiteral, "constant %q must be declared with a literal, got %s", nameToken.Raw, describe(token)).
+			label("not an integer constant, char literal, true or false")
+	}
+	if err != nil {
+		return 0, literalError(tk, token, err)
+	}
+
+	// characters are integer codes, like everywhere in Jack
+	matches := valueType != "boolean" && typeToken.Raw != "boolean"
+	if typeToken.Raw == "boolean" {
+		matches = valueType == "boolean"
+	}
+	if negative && (valueType != "int" || typeToken.Raw == "char") {
+		matches = false
+	}
+	if !matches {
+		literal := token.Raw
+		if negative {
+			literal = "-" + literal
+		}
+		return 0, errorAt(tk, token, InvalidLiteral, "constant %q is declared %s, %s is not of that type", nameToken.Raw, typeToken.Raw, literal).
+			label("not of type %s", typeToken.Raw).
+			related(tk, *typeToken, "declared %s here", typeToken.Raw)
+	}
+	processTokenOrPanics(tk, isTerm())
+
+	if negative {
+		value = -value
+	}
+	return value, nil
+}
+
+// define declares a symbol, reporting names declared twice in the same scope.
+func (c *Compiler) define(tk *tokenizer.Tokenizer, nameToken *tokenizer.Token, typeName string, kind symbols.Kind, value int) error {
+	symbol := &symbols.Symbol{
+		Name:   nameToken.Raw,
+		Type:   typeName,
+		Kind:   kind,
+		Value:  value,
+		Line:   nameToken.Line,
+		Column: nameToken.Column,
+	}
+	previous, err := c.symbols.Define(symbol)
+	if err != nil {
+		previousToken := tokenizer.Token{Raw: previous.Name, Line: previous.Line, Column: previous.Column}
+		return errorAt(tk, *nameToken, DuplicateDeclaration, "%s %q already declared", kind, nameToken.Raw).
+			related(tk, previousToken, "%s %s first declared here", previous.Kind, previous.Name)
+	}
+	return nil
+}
 
+func (c *Compiler) Subroutine(tk *tokenizer.Tokenizer, classToken *tokenizer.Token) error {
 	matcher := or(is("constructor"), is("function"), is("method"))
 	if _, ok := matcher(tk.Current); !ok {
 		return notSubroutineDec
 	}
+	c.open("subroutineDec")
+	defer c.close()
 	_, isConstructor := is("constructor")(tk.Current)
 	_, isMethod := is("method")(tk.Current)
-	processTokenOrPanics(tk, matcher)
-
-	typeToken := processTokenOrPanics(tk, is("void"), isType())
-	nameToken := processTokenOrPanics(tk, isIdentifier())
-
-	processTokenOrPanics(tk, is("("))
-	var args int
-	if isMethod {
-		args++
+	typeToken, nameToken, err := c.subroutineDec(tk, classToken)
+	if err != nil {
+		return err
 	}
-	c.ParameterList(tk, &args)
-	processTokenOrPanics(tk, is(")"))
 
 	writeSubroutine := func(nLocalVars int) {
 		c.vmw.WriteSubroutine(classToken.Raw, nameToken.Raw, nLocalVars)
 		if isConstructor {
-			c.vmw.WritePush("constant", nClassVars)
+			c.vmw.WritePush("constant", c.symbols.VarCount(symbols.Field))
 			c.vmw.WriteCall("Memory", "alloc", 1)
 			c.vmw.WritePop("pointer", 0)
 		}
@@ -113,20 +378,48 @@ func (c *Compiler) Subroutine(tk *tokenizer.Tokenizer, classToken *tokenizer.Tok
 		}
 	}
 
-	err := c.SubroutineBody(tk, typeToken, writeSubroutine)
-	if err != nil {
-		return err
+	return c.SubroutineBody(tk, typeToken, writeSubroutine)
+}
+
+// subroutineDec compiles the declaration of a subroutine up to its parameter list, returning
+// its type and name.
+func (c *Compiler) subroutineDec(tk *tokenizer.Tokenizer, classToken *tokenizer.Token) (*tokenizer.Token, *tokenizer.Token, error) {
+	_, isConstructor := is("constructor")(tk.Current)
+	kindToken := processTokenOrPanics(tk, is("constructor"), is("function"), is("method"))
+	c.subroutineKind = kindToken.Raw
+
+	typeToken := processTokenOrPanics(tk, is("void"), isType())
+	if isConstructor && typeToken.Raw != classToken.Raw {
+		return nil, nil, errorAt(tk, *typeToken, InvalidReturn, "constructor must return its class %s, got %s", classToken.Raw, typeToken.Raw)
 	}
+	nameToken := processTokenOrPanics(tk, isIdentifier())
+	c.subroutines[nameToken.Raw] = kindToken.Raw
+	c.subroutineTokens[nameToken.Raw] = *nameToken
+	c.symbols.StartSubroutine(nameToken.Raw, kindToken.Raw)
 
-	return nil
+	processTokenOrPanics(tk, is("("))
+	if err := c.ParameterList(tk); err != nil {
+		return nil, nil, err
+	}
+	processTokenOrPanics(tk, is(")"))
+	c.signatures = append(c.signatures, Signature{
+		Kind:       kindToken.Raw,
+		Name:       nameToken.Raw,
+		ReturnType: typeToken.Raw,
+		Params:     paramTypes(c.symbols.Subroutine()),
+	})
+
+	return typeToken, nameToken, nil
 }
 
 func (c *Compiler) SubroutineBody(tk *tokenizer.Tokenizer, subroutineType *tokenizer.Token, writeSubroutine func(int)) error {
+	c.open("subroutineBody")
+	defer c.close()
+
 	processTokenOrPanics(tk, is("{"))
 
-	var nvars int
-	for varLine := 0; ; varLine++ {
-		err := c.VarDec(tk, &nvars)
+	for {
+		err := c.VarDec(tk)
 		if errors.Is(err, notLocalVarDec) {
 			break
 		}
@@ -134,15 +427,33 @@ func (c *Compiler) SubroutineBody(tk *tokenizer.Tokenizer, subroutineType *token
 			return err
 		}
 	}
-	writeSubroutine(nvars)
+	start := len(c.vmw.Output())
+	writeSubroutine(c.symbols.VarCount(symbols.Var))
+
+	if err := c.Statements(tk, subroutineType); err != nil {
+		return err
+	}
 
-	c.Statements(tk, subroutineType)
+	// the vm code falling off the end of the subroutine means some path has no return
+	graph := newFlowGraph(c.vmw.Output()[start:])
+	if graph.reachesEnd() {
+		if subroutineType.Raw == "void" {
+			return errorAt(tk, tk.Current, MissingReturn, "missing return statement at the end of void subroutine")
+		}
+		return errorAt(tk, tk.Current, MissingReturn, "not all code paths return a value")
+	}
 	processTokenOrPanics(tk, is("}"))
 
 	return nil
 }
 
-func (c *Compiler) VarDec(tk *tokenizer.Tokenizer, nvars *int) error {
+func (c *Compiler) VarDec(tk *tokenizer.Tokenizer) error {
+	if _, ok := is("var")(tk.Current); !ok {
+		return notLocalVarDec
+	}
+	c.open("varDec")
+	defer c.close()
+
 	_, err := processToken(tk, is("var"))
 	if err != nil {
 		return fmt.Errorf("%w: %s", notLocalVarDec, err)
@@ -151,12 +462,9 @@ func (c *Compiler) VarDec(tk *tokenizer.Tokenizer, nvars *int) error {
 
 	for {
 		varNameToken := processTokenOrPanics(tk, isIdentifier())
-		c.subroutineSymbolTable[varNameToken.Raw] = &tokenizer.Var{
-			Index: *nvars,
-			Type:  typeToken.Raw,
-			Kind:  "var",
+		if err := c.define(tk, varNameToken, typeToken.Raw, symbols.Var, 0); err != nil {
+			return err
 		}
-		*nvars++
 
 		_, err = processToken(tk, is(","))
 		if err != nil {
@@ -169,7 +477,14 @@ func (c *Compiler) VarDec(tk *tokenizer.Tokenizer, nvars *int) error {
 }
 
 func (c *Compiler) Statements(tk *tokenizer.Tokenizer, subroutineType *tokenizer.Token) error {
+	c.open("statements")
+	defer c.close()
+
 	for {
+		if c.opts.LineComments && tk.Current.Type == tokenizer.KEYWORD && tk.Current.Raw != "var" &&
+			slices.Contains(c.statementKeywords(), tk.Current.Raw) {
+			c.vmw.WriteComment(fmt.Sprintf("line %d", tk.Current.Line))
+		}
 		if _, ok := is("let")(tk.Current); ok {
 			if err := c.Let(tk); err != nil {
 				return err
@@ -177,13 +492,13 @@ func (c *Compiler) Statements(tk *tokenizer.Tokenizer, subroutineType *tokenizer
 			continue
 		}
 		if _, ok := is("if")(tk.Current); ok {
-			if err := c.If(tk); err != nil {
+			if err := c.If(tk, subroutineType); err != nil {
 				return err
 			}
 			continue
 		}
 		if _, ok := is("while")(tk.Current); ok {
-			if err := c.While(tk); err != nil {
+			if err := c.While(tk, subroutineType); err != nil {
 				return err
 			}
 			continue
@@ -194,6 +509,26 @@ func (c *Compiler) Statements(tk *tokenizer.Tokenizer, subroutineType *tokenizer
 			}
 			continue
 		}
+		if _, ok := is("for")(tk.Current); ok && c.opts.Has(Loops) {
+			if err := c.For(tk, subroutineType); err != nil {
+				return err
+			}
+			continue
+		}
+		_, isBreak := is("break")(tk.Current)
+		_, isContinue := is("continue")(tk.Current)
+		if isBreak && (c.opts.Has(Loops) || c.opts.Has(Branches)) || isContinue && c.opts.Has(Loops) {
+			if err := c.BreakOrContinue(tk); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, ok := is("switch")(tk.Current); ok && c.opts.Has(Branches) {
+			if err := c.Switch(tk, subroutineType); err != nil {
+				return err
+			}
+			continue
+		}
 		if _, ok := is("return")(tk.Current); ok {
 			if err := c.Return(tk, subroutineType); err != nil {
 				return err
@@ -204,12 +539,50 @@ func (c *Compiler) Statements(tk *tokenizer.Tokenizer, subroutineType *tokenizer
 		break
 	}
 
-	return nil
+	if _, ok := or(is("}"), is("case"), is("default"))(tk.Current); ok {
+		return nil
+	}
+	return c.statementError(tk)
+}
+
+// statementError reports a token that starts no statement, with hints for the usual mistakes.
+func (c *Compiler) statementError(tk *tokenizer.Tokenizer) error {
+	token := tk.Current
+	d := errorAt(tk, token, UnexpectedToken, "expected a statement or \"}\", found %s", describe(token)).
+		label("not a statement")
+
+	rest := tk.RestOfLine()
+	switch {
+	case token.Raw == "var":
+		d.hint("local variables are declared at the start of the subroutine body, before any statement")
+	case token.Type != tokenizer.IDENTIFIER:
+	case strings.HasPrefix(rest, "=") || strings.HasPrefix(rest, "["):
+		d.hint("assignments start with let: let %s %s", token.Raw, rest)
+	case strings.HasPrefix(rest, "(") || strings.HasPrefix(rest, "."):
+		d.hint("subroutine calls whose value is not used start with do: do %s%s", token.Raw, rest)
+	default:
+		d.didYouMean(token.Raw, c.statementKeywords())
+	}
+	return d
 }
 
-func (c *Compiler) While(tk *tokenizer.Tokenizer) error {
+func (c *Compiler) statementKeywords() []string {
+	keywords := []string{"let", "do", "if", "while", "return", "var"}
+	if c.opts.Has(Loops) {
+		keywords = append(keywords, "for", "break", "continue")
+	}
+	if c.opts.Has(Branches) {
+		keywords = append(keywords, "switch", "break")
+	}
+	return keywords
+}
+
+func (c *Compiler) While(tk *tokenizer.Tokenizer, subroutineType *tokenizer.Token) error {
+	c.open("whileStatement")
+	defer c.close()
+
 	processTokenOrPanics(tk, is("while"))
-	c.vmw.WriteWhile(
+	return c.vmw.WriteWhile(
 		func() error {
 			processTokenOrPanics(tk, is("("))
 			if err := c.Expression(tk); err != nil {
@@ -221,7 +594,7 @@ func (c *Compiler) While(tk *tokenizer.Tokenizer) error {
 		},
 		func() error {
 			processTokenOrPanics(tk, is("{"))
-			if err := c.Statements(tk, nil); err != nil {
+			if err := c.Statements(tk, subroutineType); err != nil {
 				return err
 			}
 			processTokenOrPanics(tk, is("}"))
@@ -229,11 +602,72 @@ func (c *Compiler) While(tk *tokenizer.Tokenizer) error {
 			return nil
 		},
 	)
+}
+
+func (c *Compiler) For(tk *tokenizer.Tokenizer, subroutineType *tokenizer.Token) error {
+	c.open("forStatement")
+	defer c.close()
+
+	processTokenOrPanics(tk, is("for"))
+	processTokenOrPanics(tk, is("("))
+	if err := c.Assignment(tk); err != nil {
+		return err
+	}
+	processTokenOrPanics(tk, is(";"))
+
+	return c.vmw.WriteFor(
+		func() error {
+			if err := c.Expression(tk); err != nil {
+				return err
+			}
+			processTokenOrPanics(tk, is(";"))
+
+			return nil
+		},
+		func() error {
+			if err := c.Assignment(tk); err != nil {
+				return err
+			}
+			processTokenOrPanics(tk, is(")"))
+
+			return nil
+		},
+		func() error {
+			processTokenOrPanics(tk, is("{"))
+			if err := c.Statements(tk, subroutineType); err != nil {
+				return err
+			}
+			processTokenOrPanics(tk, is("}"))
+
+			return nil
+		},
+	)
+}
+
+func (c *Compiler) BreakOrContinue(tk *tokenizer.Tokenizer) error {
+	c.open(tk.Current.Raw + "Statement")
+	defer c.close()
+
+	keywordToken := processTokenOrPanics(tk, or(is("break"), is("continue")))
+
+	var err error
+	if keywordToken.Raw == "break" {
+		err = c.vmw.WriteBreak()
+	} else {
+		err = c.vmw.WriteContinue()
+	}
+	if err != nil {
+		return errorAt(tk, *keywordToken, InvalidJump, "%s", err)
+	}
+	processTokenOrPanics(tk, is(";"))
 
 	return nil
 }
 
-func (c *Compiler) If(tk *tokenizer.Tokenizer) error {
+func (c *Compiler) If(tk *tokenizer.Tokenizer, subroutineType *tokenizer.Token) error {
+	c.open("ifStatement")
+	defer c.close()
+
 	processTokenOrPanics(tk, is("if"))
 	processTokenOrPanics(tk, is("("))
 	if err := c.Expression(tk); err != nil {
@@ -242,9 +676,9 @@ func (c *Compiler) If(tk *tokenizer.Tokenizer) error {
 	processTokenOrPanics(tk, is(")"))
 	processTokenOrPanics(tk, is("{"))
 
-	c.vmw.WriteIf(
+	return c.vmw.WriteIf(
 		func() error {
-			if err := c.Statements(tk, nil); err != nil {
+			if err := c.Statements(tk, subroutineType); err != nil {
 				return err
 			}
 			processTokenOrPanics(tk, is("}"))
@@ -257,8 +691,11 @@ func (c *Compiler) If(tk *tokenizer.Tokenizer) error {
 				return nil
 			}
 			processTokenOrPanics(tk, is("else"))
+			if _, ok := is("if")(tk.Current); ok && c.opts.Has(Branches) {
+				return c.If(tk, subroutineType)
+			}
 			processTokenOrPanics(tk, is("{"))
-			if err := c.Statements(tk, nil); err != nil {
+			if err := c.Statements(tk, subroutineType); err != nil {
 				return err
 			}
 			processTokenOrPanics(tk, is("}"))
@@ -266,12 +703,122 @@ func (c *Compiler) If(tk *tokenizer.Tokenizer) error {
 			return nil
 		},
 	)
+}
+
+// Switch compiles switch (expression) { case constant: statements ... default: statements },
+// as a chain of ifs comparing the value stored in temp 1 against each case. Cases do not fall
+// through, and break leaves the switch.
+func (c *Compiler) Switch(tk *tokenizer.Tokenizer, subroutineType *tokenizer.Token) error {
+	c.open("switchStatement")
+	defer c.close()
+
+	processTokenOrPanics(tk, is("switch"))
+	processTokenOrPanics(tk, is("("))
+	if err := c.Expression(tk); err != nil {
+		return err
+	}
+	processTokenOrPanics(tk, is(")"))
+	processTokenOrPanics(tk, is("{"))
+	// temp 1 holds the value only for the case comparisons, which run one after the other
+	// with nothing in between, the first case matching jumps to its body and never reads it
+	// again. No code may be emitted between the comparisons, or it could overwrite temp 1,
+	// which nothing reserves.
+	c.vmw.WritePop("temp", 1)
+
+	err := c.vmw.WriteSwitch(func() error {
+		return c.switchCases(tk, subroutineType, make(map[int]tokenizer.Token))
+	})
+	if err != nil {
+		return err
+	}
+	processTokenOrPanics(tk, is("}"))
 
 	return nil
 }
 
+// switchCases compiles the cases of a switch, seen maps the values of the cases before to their tokens.
+func (c *Compiler) switchCases(tk *tokenizer.Tokenizer, subroutineType *tokenizer.Token, seen map[int]tokenizer.Token) error {
+	if _, ok := is("default")(tk.Current); ok {
+		defaultToken := processTokenOrPanics(tk, is("default"))
+		processTokenOrPanics(tk, is(":"))
+		if err := c.Statements(tk, subroutineType); err != nil {
+			return err
+		}
+		if _, ok := is("}")(tk.Current); !ok {
+			return errorAt(tk, tk.Current, InvalidSwitch, "default must be the last case of a switch").
+				related(tk, *defaultToken, "default case here")
+		}
+		return nil
+	}
+	if _, ok := is("case")(tk.Current); !ok {
+		return nil
+	}
+
+	processTokenOrPanics(tk, is("case"))
+	valueToken := tk.Current
+	value, err := c.caseConstant(tk)
+	if err != nil {
+		return err
+	}
+	if previous, ok := seen[value]; ok {
+		return errorAt(tk, valueToken, InvalidSwitch, "duplicate case %d in switch", value).
+			related(tk, previous, "case %d first used here", value)
+	}
+	seen[value] = valueToken
+	processTokenOrPanics(tk, is(":"))
+
+	// the switch value, see Switch
+	c.vmw.WritePush("temp", 1)
+	c.writeConstant(value)
+	c.vmw.WriteArithmetic("=")
+
+	return c.vmw.WriteIf(
+		func() error {
+			return c.Statements(tk, subroutineType)
+		},
+		func() error {
+			return c.switchCases(tk, subroutineType, seen)
+		},
+	)
+}
+
+// caseConstant reads an integer constant, optionally negated, or a char literal when enabled.
+func (c *Compiler) caseConstant(tk *tokenizer.Tokenizer) (int, error) {
+	_, negative := is("-")(tk.Current)
+	if negative {
+		processTokenOrPanics(tk, is("-"))
+	}
+
+	token := tk.Current
+	var value int
+	var err error
+	switch token.Type {
+	case tokenizer.INT_CONST:
+		value, err = intConstant(token.Raw)
+	case tokenizer.CHAR_CONST:
+		value, err = charLiteral(token.Raw)
+	default:
+		return 0, errorAt(tk, token, InvalidSwitch, "case must be an integer constant, got %q", token.Raw)
+	}
+	if err != nil {
+		return 0, literalError(tk, token, err)
+	}
+	processTokenOrPanics(tk, isTerm())
+
+	if negative {
+		value = -value
+	}
+	return value, nil
+}
+
 func (c *Compiler) Do(tk *tokenizer.Tokenizer) error {
+	c.open("doStatement")
+	defer c.close()
+
 	processTokenOrPanics(tk, is("do"))
+	// the grammar has the subroutine call right in the do statement
+	c.leaveOut("expression", "term")
+	defer c.leaveOut()
 	if err := c.Expression(tk); err != nil {
 		return err
 	}
@@ -283,16 +830,35 @@ func (c *Compiler) Do(tk *tokenizer.Tokenizer) error {
 }
 
 func (c *Compiler) Let(tk *tokenizer.Tokenizer) error {
+	c.open("letStatement")
+	defer c.close()
+
 	processTokenOrPanics(tk, is("let"))
+	if err := c.Assignment(tk); err != nil {
+		return err
+	}
+	processTokenOrPanics(tk, is(";"))
 
+	return nil
+}
+
+// Assignment compiles varName([expression])? = expression, shared by let and for statements.
+func (c *Compiler) Assignment(tk *tokenizer.Tokenizer) error {
 	termToken := processTokenOrPanics(tk, isIdentifier())
 	_var, err := c.enforceVarDec(tk, termToken)
 	if err != nil {
 		return err
 	}
+	// a class name is no variable to assign
+	if _var == nil {
+		return c.undeclaredVariable(tk, termToken)
+	}
+	if _var.Kind == symbols.Const {
+		return errorAt(tk, *termToken, AssignToConstant, "cannot assign to constant %q", termToken.Raw)
+	}
 
 	if _, ok := is("[")(tk.Current); ok {
-		c.vmw.WritePush(vm.VarTypes[_var.Kind], _var.Index)
+		c.vmw.WritePush(vm.VarTypes[string(_var.Kind)], _var.Index)
 
 		processTokenOrPanics(tk, is("["))
 		if err := c.Expression(tk); err != nil {
@@ -302,8 +868,13 @@ func (c *Compiler) Let(tk *tokenizer.Tokenizer) error {
 
 		c.vmw.WriteArithmetic("+")
 
-		processTokenOrPanics(tk, is("="))
-		if err = c.Expression(tk); err != nil {
+		// the element address stays on the stack, that 0 is read through it without computing it again
+		err = c.assignedValue(tk, func() {
+			c.vmw.WritePop("pointer", 1)
+			c.vmw.WritePush("pointer", 1)
+			c.vmw.WritePush("that", 0)
+		})
+		if err != nil {
 			return err
 		}
 
@@ -313,34 +884,69 @@ func (c *Compiler) Let(tk *tokenizer.Tokenizer) error {
 		c.vmw.WritePop("that", 0)
 
 	} else {
-		processTokenOrPanics(tk, is("="))
-		if err = c.Expression(tk); err != nil {
+		err = c.assignedValue(tk, func() {
+			c.vmw.WritePush(vm.VarTypes[string(_var.Kind)], _var.Index)
+		})
+		if err != nil {
 			return err
 		}
-		c.vmw.WritePop(vm.VarTypes[_var.Kind], _var.Index)
+		c.vmw.WritePop(vm.VarTypes[string(_var.Kind)], _var.Index)
 	}
 
-	processTokenOrPanics(tk, is(";"))
+	return nil
+}
+
+// assignedValue compiles = expression, or when compound assignments are enabled, also
+// op= expression, ++ and --, where pushCurrent pushes the value being updated.
+func (c *Compiler) assignedValue(tk *tokenizer.Tokenizer, pushCurrent func()) error {
+	if _, ok := is("=")(tk.Current); ok || !c.opts.Has(CompoundAssignment) {
+		processTokenOrPanics(tk, is("="))
+		return c.Expression(tk)
+	}
+
+	opToken := processTokenOrPanics(tk, is("+"), is("-"), is("*"), is("/"))
+	pushCurrent()
+
+	_, isStep := is(opToken.Raw)(tk.Current)
+	if isStep && (opToken.Raw == "+" || opToken.Raw == "-") {
+		processTokenOrPanics(tk, is(opToken.Raw))
+		c.vmw.WritePush("constant", 1)
+	} else {
+		processTokenOrPanics(tk, is("="))
+		if err := c.Expression(tk); err != nil {
+			return err
+		}
+	}
+	c.vmw.WriteArithmetic(opToken.Raw)
 
 	return nil
 }
 
 func (c *Compiler) ExpressionList(tk *tokenizer.Tokenizer) (int, error) {
-	var n int
+	types, err := c.expressionList(tk)
+	return len(types), err
+}
+
+// expressionList compiles the expressions, returning the type of each one, when it can be told.
+func (c *Compiler) expressionList(tk *tokenizer.Tokenizer) ([]string, error) {
+	c.open("expressionList")
+	defer c.close()
+
+	var types []string
 
 	if _, ok := is(")")(tk.Current); ok {
-		return n, nil
+		return types, nil
 	}
 
 	for {
-		err := c.Expression(tk)
+		exprType, err := c.expression(tk)
 		if errors.Is(err, notExpressionDec) {
 			break
 		}
 		if err != nil {
-			return n, err
+			return types, err
 		}
-		n++
+		types = append(types, exprType)
 
 		if _, ok := is(",")(tk.Current); !ok {
 			break
@@ -348,57 +954,143 @@ func (c *Compiler) ExpressionList(tk *tokenizer.Tokenizer) (int, error) {
 		processTokenOrPanics(tk, is(","))
 	}
 
-	return n, nil
+	return types, nil
 }
 
 func (c *Compiler) Expression(tk *tokenizer.Tokenizer) error {
+	_, err := c.expression(tk)
+	if errors.Is(err, notExpressionDec) {
+		return errorAt(tk, tk.Current, UnexpectedToken, "expected an expression, found %s", describe(tk.Current)).
+			label("expected an expression")
+	}
+	return err
+}
+
+// expression compiles an expression returning its type, when it can be told at compile time.
+func (c *Compiler) expression(tk *tokenizer.Tokenizer) (string, error) {
 	if _, ok := or(is(";"), is(")"))(tk.Current); ok {
-		return notExpressionDec
+		return "", notExpressionDec
 	}
+	c.open("expression")
+	defer c.close()
 
-	if err := c.Term(tk); err != nil {
-		return err
+	startToken := tk.Current
+	var ops []string
+	exprType, err := c.binaryExpression(tk, 0, &ops)
+	if err != nil {
+		return "", err
+	}
+	if c.opts.Strict && precedenceMatters(ops) {
+		c.warnings = append(c.warnings, warningAt(tk, startToken, EvaluationOrder,
+			"expression evaluates differently left to right and with operator precedence, use parentheses",
+		))
+	}
+
+	return exprType, nil
+}
+
+// binaryExpression compiles term (op term)*, left to right by default, or by precedence climbing
+// when operator precedence is enabled, in which case only operators binding tighter than
+// minPrecedence are consumed. Operators are collected in source order.
+func (c *Compiler) binaryExpression(tk *tokenizer.Tokenizer, minPrecedence int, ops *[]string) (string, error) {
+	lhsType, err := c.term(tk)
+	if err != nil {
+		return "", err
 	}
 
+	withPrecedence := c.opts.Has(Precedence)
 	for {
-		if _, ok := isOp()(tk.Current); !ok {
+		op, ok := isOp()(tk.Current)
+		if !ok {
+			break
+		}
+		if withPrecedence && opPrecedence[op] < minPrecedence {
 			break
 		}
 
 		opToken := processTokenOrPanics(tk, isOp())
-
-		err := c.Term(tk)
-		if err != nil {
+		*ops = append(*ops, opToken.Raw)
+
+		var rhsType string
+		rhs, err := c.vmw.Capture(func() error {
+			var err error
+			if withPrecedence {
+				rhsType, err = c.binaryExpression(tk, opPrecedence[op]+1, ops)
+			} else {
+				rhsType, err = c.term(tk)
+			}
 			return err
+		})
+		if err != nil {
+			return "", err
 		}
 
-		c.vmw.WriteArithmetic(opToken.Raw)
+		lhsType = c.writeOperator(opToken.Raw, lhsType, rhsType, rhs)
 	}
 
-	return nil
+	return lhsType, nil
+}
+
+func (c *Compiler) writeOperator(op, lhsType, rhsType, rhs string) string {
+	bothBoolean := lhsType == "boolean" && rhsType == "boolean"
+	if bothBoolean && (op == "&" || op == "|") && c.opts.Has(ShortCircuit) {
+		c.vmw.WriteShortCircuit(op, rhs)
+		return "boolean"
+	}
+
+	c.vmw.WriteCode(rhs)
+	c.vmw.WriteArithmetic(op)
+
+	switch op {
+	case "<", ">", "=":
+		return "boolean"
+	case "&", "|":
+		if bothBoolean {
+			return "boolean"
+		}
+	}
+	return "int"
 }
 
 func (c *Compiler) Term(tk *tokenizer.Tokenizer) error {
+	_, err := c.term(tk)
+	return err
+}
+
+func (c *Compiler) term(tk *tokenizer.Tokenizer) (string, error) {
+	c.open("term")
+	defer c.close()
+
 	// unaryOp term
 	if _, ok := isUnaryOp()(tk.Current); ok {
 		opToken := processTokenOrPanics(tk, isUnaryOp())
-		if err := c.Term(tk); err != nil {
-			return err
+		termType, err := c.term(tk)
+		if err != nil {
+			return "", err
 		}
 		c.vmw.WriteUnary(opToken.Raw)
 
-		return nil
+		if opToken.Raw == "~" && termType == "boolean" {
+			return "boolean", nil
+		}
+		return "int", nil
 	}
 
 	// (expression)
 	if _, ok := is("(")(tk.Current); ok {
 		processTokenOrPanics(tk, is("("))
-		if err := c.Expression(tk); err != nil {
-			return err
+		exprType, err := c.expression(tk)
+		if err != nil {
+			return "", err
 		}
 		processTokenOrPanics(tk, is(")"))
 
-		return nil
+		return exprType, nil
+	}
+
+	// [expression, ...]
+	if _, ok := is("[")(tk.Current); ok && c.opts.Has(Literals) {
+		return c.arrayLiteral(tk)
 	}
 
 	// varName / subroutineName
@@ -406,33 +1098,39 @@ func (c *Compiler) Term(tk *tokenizer.Tokenizer) error {
 
 	// (method call)
 	if _, ok := is("(")(tk.Current); ok {
-		_var := c.classSymbolTable["this"] // this will always be present here
+		_var, _ := c.symbols.Lookup("this") // this will always be present here
 		c.vmw.WritePush("pointer", 0)
 
 		processTokenOrPanics(tk, is("("))
-		n, err := c.ExpressionList(tk)
+		argTypes, err := c.expressionList(tk)
 		if err != nil && !errors.Is(err, notExpressionDec) {
-			return err
+			return "", err
 		}
 		processTokenOrPanics(tk, is(")"))
+		c.unqualified = append(c.unqualified, unqualifiedCall{
+			name:       termToken.Raw,
+			callerKind: c.subroutineKind,
+			token:      *termToken,
+			argTypes:   argTypes,
+		})
 
-		c.vmw.WriteCall(_var.Type, termToken.Raw, n+1) // +1, given this is pushed to the stack
+		c.vmw.WriteCall(_var.Type, termToken.Raw, len(argTypes)+1) // +1, given this is pushed to the stack
 
-		return nil
+		return "", nil
 	}
 
 	_var, err := c.enforceVarDec(tk, termToken)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	// [expression]
 	if _, ok := is("[")(tk.Current); ok {
-		c.vmw.WritePush(vm.VarTypes[_var.Kind], _var.Index)
+		c.vmw.WritePush(vm.VarTypes[string(_var.Kind)], _var.Index)
 
 		processTokenOrPanics(tk, is("["))
 		if err := c.Expression(tk); err != nil {
-			return err
+			return "", err
 		}
 		processTokenOrPanics(tk, is("]"))
 
@@ -440,73 +1138,260 @@ func (c *Compiler) Term(tk *tokenizer.Tokenizer) error {
 		c.vmw.WritePop("pointer", 1)
 		c.vmw.WritePush("that", 0)
 
-		return nil
+		return "", nil
 	}
 
 	// subroutineCall
 	if _, ok := is(".")(tk.Current); ok {
 		caller := termToken.Raw
 		if _var != nil {
-			c.vmw.WritePush(vm.VarTypes[_var.Kind], _var.Index)
+			c.vmw.WritePush(vm.VarTypes[string(_var.Kind)], _var.Index)
 			caller = _var.Type
 		}
 
 		processTokenOrPanics(tk, is("."))
 		subroutineNameToken := processTokenOrPanics(tk, isIdentifier())
+		if _, ok := is("(")(tk.Current); !ok && _var == nil && c.opts.Has(Constants) {
+			return c.classConstant(tk, termToken.Raw, subroutineNameToken)
+		}
 		processTokenOrPanics(tk, is("("))
-		n, err := c.ExpressionList(tk)
+		argTypes, err := c.expressionList(tk)
 		if err != nil {
-			return err
+			return "", err
 		}
 		processTokenOrPanics(tk, is(")"))
+		returnType, err := c.checkCall(tk, caller, *subroutineNameToken, _var != nil, argTypes)
+		if err != nil {
+			return "", err
+		}
 
+		n := len(argTypes)
 		if _var != nil {
 			// when is a method call, previous push instruction is pushing the this obj to the stack
 			n++
 		}
+		c.reference(caller)
 		c.vmw.WriteCall(caller, subroutineNameToken.Raw, n)
 
-		return nil
+		return returnType, nil
 	}
 
+	if termToken.Type == tokenizer.IDENTIFIER && _var.Kind == symbols.Const {
+		c.writeConstant(_var.Value)
+		return _var.Type, nil
+	}
 	if termToken.Type == tokenizer.IDENTIFIER {
-		c.vmw.WritePush(vm.VarTypes[_var.Kind], _var.Index)
+		c.vmw.WritePush(vm.VarTypes[string(_var.Kind)], _var.Index)
+		return _var.Type, nil
 	}
 	if termToken.Type == tokenizer.INT_CONST {
-		c.vmw.WritePush("constant", termToken.Raw)
+		n, err := intConstant(termToken.Raw)
+		if err != nil {
+			return "", literalError(tk, *termToken, err)
+		}
+		c.vmw.WritePush("constant", n)
+		return "int", nil
 	}
 	if termToken.Type == tokenizer.STRING_CONST {
-		c.vmw.WritePush("constant", len(termToken.Raw)-2)
-		c.vmw.WriteCall("String", "new", 1)
-		for _, char := range termToken.Raw {
-			if char == '"' {
-				continue
+		chars, err := literalChars(termToken.Raw, c.opts.Has(CharLiterals))
+		if err == nil {
+			err = checkHackChars(termToken.Raw, chars)
+		}
+		if err != nil {
+			return "", literalError(tk, *termToken, err)
+		}
+		// adjacent string constants, possibly spanning multiple lines, are concatenated
+		for tk.Current.Type == tokenizer.STRING_CONST && c.opts.Has(Literals) {
+			nextToken := processTokenOrPanics(tk, isTerm())
+			nextChars, err := literalChars(nextToken.Raw, c.opts.Has(CharLiterals))
+			if err == nil {
+				err = checkHackChars(nextToken.Raw, nextChars)
+			}
+			if err != nil {
+				return "", literalError(tk, *nextToken, err)
 			}
-			c.vmw.WritePush("constant", char)
-			c.vmw.WriteCall("String", "appendChar", 2) // 2 because 1 is the string ref, 1 is the char
+			chars = append(chars, nextChars...)
+		}
+		if c.opts.InternStrings {
+			c.writeInternedString(chars)
+		} else {
+			c.writeString(chars)
 		}
+		return "String", nil
+	}
+	if termToken.Type == tokenizer.CHAR_CONST {
+		char, err := charLiteral(termToken.Raw)
+		if err == nil {
+			err = checkHackChars(termToken.Raw, []int{char})
+		}
+		if err != nil {
+			return "", literalError(tk, *termToken, err)
+		}
+		c.vmw.WritePush("constant", char)
+		return "char", nil
 	}
 	if termToken.Type != tokenizer.KEYWORD {
-		return nil
+		return "", nil
 	}
-	if _var == nil {
-		c.vmw.WriteKeyword(termToken.Raw)
-	} else {
-		c.vmw.WritePush(vm.VarTypes[_var.Kind], _var.Index)
+	if _var != nil {
+		c.vmw.WritePush(vm.VarTypes[string(_var.Kind)], _var.Index)
+		return _var.Type, nil
+	}
+	c.vmw.WriteKeyword(termToken.Raw)
+	if termToken.Raw == "true" || termToken.Raw == "false" {
+		return "boolean", nil
 	}
 
-	return nil
+	return "", nil
+}
+
+// arrayLiteral compiles [expression, ...] into a new array, storing each element with the same
+// sequence as let, while keeping the array reference on the stack.
+func (c *Compiler) arrayLiteral(tk *tokenizer.Tokenizer) (string, error) {
+	openToken := processTokenOrPanics(tk, is("["))
+
+	var n int
+	stores, err := c.vmw.Capture(func() error {
+		for {
+			if _, ok := is("]")(tk.Current); ok {
+				return nil
+			}
+
+			// duplicates the array reference through that, to compute the element address
+			c.vmw.WritePop("pointer", 1)
+			c.vmw.WritePush("pointer", 1)
+			c.vmw.WritePush("pointer", 1)
+			c.vmw.WritePush("constant", n)
+			c.vmw.WriteArithmetic("+")
+			if err := c.Expression(tk); err != nil {
+				return err
+			}
+			c.vmw.WritePop("temp", 0)
+			c.vmw.WritePop("pointer", 1)
+			c.vmw.WritePush("temp", 0)
+			c.vmw.WritePop("that", 0)
+			n++
+
+			if _, ok := is(",")(tk.Current); !ok {
+				return nil
+			}
+			processTokenOrPanics(tk, is(","))
+		}
+	})
+	if err != nil {
+		return "", err
+	}
+	if n == 0 {
+		return "", errorAt(tk, *openToken, InvalidLiteral, "array literal must have at least one element")
+	}
+	processTokenOrPanics(tk, is("]"))
+
+	c.vmw.WritePush("constant", n)
+	c.vmw.WriteCall("Array", "new", 1)
+	c.vmw.WriteCode(stores)
+
+	return "Array", nil
+}
+
+// classConstant compiles a ClassName.CONSTANT reference, inlining its value.
+func (c *Compiler) classConstant(tk *tokenizer.Tokenizer, className string, nameToken *tokenizer.Token) (string, error) {
+	name := nameToken.Raw
+	if className == c.symbols.ClassName() {
+		if _var, ok := c.symbols.Class().Lookup(name); ok && _var.Kind == symbols.Const {
+			c.writeConstant(_var.Value)
+			return _var.Type, nil
+		}
+	}
+
+	c.reference(className)
+	constant, ok := c.opts.Constants[className][name]
+	if !ok {
+		return "", errorAt(tk, *nameToken, UndeclaredConstant, "constant %s.%s not declared", className, name)
+	}
+	c.writeConstant(constant.Value)
+
+	return constant.Type, nil
+}
+
+func (c *Compiler) writeString(chars []int) {
+	c.vmw.WritePush("constant", len(chars))
+	c.vmw.WriteCall("String", "new", 1)
+	for _, char := range chars {
+		c.vmw.WritePush("constant", char)
+		c.vmw.WriteCall("String", "appendChar", 2) // 2 because 1 is the string ref, 1 is the char
+	}
+}
+
+// writeInternedString pushes the static holding the string, guarded by a call to the class
+// strings init routine, which creates every distinct string constant once.
+func (c *Compiler) writeInternedString(chars []int) {
+	key := fmt.Sprint(chars)
+	index, ok := c.internedIndex[key]
+	if !ok {
+		index = len(c.interned)
+		c.internedIndex[key] = index
+		c.interned = append(c.interned, chars)
+	}
+
+	className := c.symbols.ClassName()
+	c.vmw.WriteInitGuard(className, stringsInitRoutine, c.staticCount)
+	c.vmw.WritePush("static", c.staticCount+1+index)
+}
+
+// writeStringsInit writes the routine creating the interned strings of the class, setting
+// the static flag right after the last static declared in the class.
+func (c *Compiler) writeStringsInit(className string) {
+	if len(c.interned) == 0 {
+		return
+	}
+
+	c.vmw.WriteSubroutine(className, stringsInitRoutine, 0)
+	c.vmw.WriteKeyword("true")
+	c.vmw.WritePop("static", c.staticCount)
+	for i, chars := range c.interned {
+		c.writeString(chars)
+		c.vmw.WritePop("static", c.staticCount+1+i)
+	}
+	c.vmw.WritePush("constant", 0)
+	c.vmw.WriteReturn()
+}
+
+func (c *Compiler) writeConstant(value int) {
+	if value < 0 {
+		c.vmw.WritePush("constant", -value)
+		c.vmw.WriteUnary("-")
+		return
+	}
+	c.vmw.WritePush("constant", value)
 }
 
 func (c *Compiler) Return(tk *tokenizer.Tokenizer, subroutineType *tokenizer.Token) error {
-	processTokenOrPanics(tk, is("return"))
-	err := c.Expression(tk)
+	c.open("returnStatement")
+	defer c.close()
+
+	returnToken := processTokenOrPanics(tk, is("return"))
+	isVoid := subroutineType.Raw == "void"
+	valueToken := tk.Current
+	start := len(c.vmw.Output())
+	_, err := c.expression(tk)
 	if err != nil && !errors.Is(err, notExpressionDec) {
 		return err
 	}
+	hasValue := err == nil
+	if isVoid && hasValue {
+		return errorAt(tk, valueToken, InvalidReturn, "void subroutine cannot return a value").
+			related(tk, *subroutineType, "subroutine declared void here")
+	}
+	if !isVoid && !hasValue {
+		return errorAt(tk, *returnToken, InvalidReturn, "missing return value, subroutine returns %s", subroutineType.Raw).
+			related(tk, *subroutineType, "return type declared here")
+	}
+	if c.subroutineKind == "constructor" && c.vmw.Output()[start:] != "push pointer 0\n" {
+		return errorAt(tk, valueToken, InvalidReturn, "constructor must return this")
+	}
 	processTokenOrPanics(tk, is(";"))
 
-	if subroutineType != nil && subroutineType.Raw == "void" {
+	if isVoid {
 		c.vmw.WritePush("constant", 0)
 	}
 	c.vmw.WriteReturn()
@@ -514,18 +1399,19 @@ func (c *Compiler) Return(tk *tokenizer.Tokenizer, subroutineType *tokenizer.Tok
 	return nil
 }
 
-func (c *Compiler) ParameterList(tk *tokenizer.Tokenizer, args *int) error {
-	for i := *args; ; i++ {
+func (c *Compiler) ParameterList(tk *tokenizer.Tokenizer) error {
+	c.open("parameterList")
+	defer c.close()
+
+	for {
 		if _, ok := isType()(tk.Current); !ok {
 			break
 		}
 
 		typeToken := processTokenOrPanics(tk, isType())
 		varNameToken := processTokenOrPanics(tk, isIdentifier())
-		c.subroutineSymbolTable[varNameToken.Raw] = &tokenizer.Var{
-			Type:  typeToken.Raw,
-			Kind:  "arg",
-			Index: i,
+		if err := c.define(tk, varNameToken, typeToken.Raw, symbols.Arg, 0); err != nil {
+			return err
 		}
 
 		_, err := processToken(tk, is(","))
@@ -537,31 +1423,57 @@ func (c *Compiler) ParameterList(tk *tokenizer.Tokenizer, args *int) error {
 	return nil
 }
 
-func (c *Compiler) enforceVarDec(tk *tokenizer.Tokenizer, termToken *tokenizer.Token) (*tokenizer.Var, error) {
-	subroutineSymbol, inSubroutineDec := c.subroutineSymbolTable[termToken.Raw]
-	classSymbol, inClassDec := c.classSymbolTable[termToken.Raw]
-	found := inSubroutineDec || inClassDec
-	// the jack compiler performs no linking, so if the term starts with a uppercased letter,
-	// it assumes this class will be available at runtime
-	isClassName := regexp.MustCompile("[A-Z].*").Match([]byte(termToken.Raw))
+// variableNames are the names in scope, for suggestions.
+func (c *Compiler) variableNames() []string {
+	var names []string
+	for _, scope := range []*symbols.Scope{c.symbols.Subroutine(), c.symbols.Class()} {
+		for _, symbol := range scope.Symbols() {
+			if symbol.Kind != symbols.Class {
+				names = append(names, symbol.Name)
+			}
+		}
+	}
+	return names
+}
+
+func (c *Compiler) enforceVarDec(tk *tokenizer.Tokenizer, termToken *tokenizer.Token) (*symbols.Symbol, error) {
+	symbol, found := c.symbols.Lookup(termToken.Raw)
+	// the jack compiler performs no linking, so if the term starts with a uppercased letter and
+	// is followed by a dot, it assumes this class will be available at runtime
+	_, dotted := is(".")(tk.Current)
+	isClassName := dotted && regexp.MustCompile("[A-Z].*").Match([]byte(termToken.Raw))
 
-	err := fmt.Errorf("line %d: %q\nvariable %q not declared", tk.LineNr, tk.CurrentLine, termToken.Raw)
 	if termToken.Type == tokenizer.IDENTIFIER && !found && !isClassName {
-		return nil, err
+		return nil, c.undeclaredVariable(tk, termToken)
 	}
 
-	if inSubroutineDec {
-		return subroutineSymbol, nil
+	if !found {
+		return nil, nil
+	}
+	if c.subroutineKind == "function" && symbol.Kind == symbols.Field {
+		return nil, errorAt(tk, *termToken, NoThisObject, "field %q cannot be accessed from a function", termToken.Raw)
 	}
-	if inClassDec {
-		return classSymbol, nil
+	if c.subroutineKind == "function" && symbol.Kind == symbols.Class {
+		return nil, errorAt(tk, *termToken, NoThisObject, "this cannot be used in a function")
 	}
 
-	return nil, nil
+	return symbol, nil
+}
+
+func (c *Compiler) undeclaredVariable(tk *tokenizer.Tokenizer, termToken *tokenizer.Token) *Diagnostic {
+	return errorAt(tk, *termToken, UndeclaredVariable, "variable %q not declared", termToken.Raw).
+		label("not declared in this scope").
+		didYouMean(termToken.Raw, c.variableNames())
+}
+
+func (c *Compiler) Warnings() []*Diagnostic {
+	return c.warnings
 }
 
-func New(buf *vm.Writer) Compiler {
+func New(buf *vm.Writer, opts Options) Compiler {
 	return Compiler{
-		vmw: buf,
+		vmw:     buf,
+		opts:    opts,
+		symbols: symbols.New(),
 	}
 }
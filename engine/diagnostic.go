@@ -0,0 +1,199 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hlmerscher/jack-compiler-go/tokenizer"
+)
+
+// Code identifies the kind of a diagnostic, it stays the same across versions so tools can rely on it.
+// Codes 1xxx are syntax errors, 2xxx semantic errors and 3xxx warnings.
+type Code string
+
+const (
+	ReadError            = Code("JACK0001")
+	UnexpectedToken      = Code("JACK1001")
+	UnknownToken         = Code("JACK1002")
+	InvalidLiteral       = Code("JACK1003")
+	UndeclaredSubroutine = Code("JACK2001")
+	InvalidCall          = Code("JACK2002")
+	UndeclaredVariable   = Code("JACK2003")
+	NoThisObject         = Code("JACK2004")
+	InvalidReturn        = Code("JACK2005")
+	MissingReturn        = Code("JACK2006")
+	AssignToConstant     = Code("JACK2007")
+	UndeclaredConstant   = Code("JACK2008")
+	InvalidSwitch        = Code("JACK2009")
+	InvalidJump          = Code("JACK2010")
+	DuplicateDeclaration = Code("JACK2011")
+	WrongArgumentCount   = Code("JACK2012")
+	EvaluationOrder      = Code("JACK3001")
+	ArgumentType         = Code("JACK3002")
+)
+
+var codeDescriptions = map[Code]string{
+	ReadError:            "source file cannot be read",
+	UnexpectedToken:      "unexpected token",
+	UnknownToken:         "unknown token",
+	InvalidLiteral:       "invalid literal",
+	UndeclaredSubroutine: "undeclared subroutine",
+	InvalidCall:          "subroutine called the wrong way",
+	UndeclaredVariable:   "undeclared variable",
+	NoThisObject:         "this object used in a function",
+	InvalidReturn:        "invalid return",
+	MissingReturn:        "missing return statement",
+	AssignToConstant:     "assignment to a constant",
+	UndeclaredConstant:   "undeclared constant",
+	InvalidSwitch:        "invalid switch statement",
+	InvalidJump:          "break or continue outside of a loop",
+	DuplicateDeclaration: "name declared twice in the same scope",
+	WrongArgumentCount:   "wrong number of arguments",
+	EvaluationOrder:      "expression depends on operator evaluation order",
+	ArgumentType:         "argument of the wrong type",
+}
+
+// Codes lists every diagnostic code, in order.
+func Codes() []Code {
+	return []Code{
+		ReadError, UnexpectedToken, UnknownToken, InvalidLiteral,
+		UndeclaredSubroutine, InvalidCall, UndeclaredVariable, NoThisObject, InvalidReturn,
+		MissingReturn, AssignToConstant, UndeclaredConstant, InvalidSwitch, InvalidJump,
+		DuplicateDeclaration, WrongArgumentCount, EvaluationOrder, ArgumentType,
+	}
+}
+
+func (c Code) Description() string {
+	return codeDescriptions[c]
+}
+
+type Severity string
+
+const (
+	SeverityError   = Severity("error")
+	SeverityWarning = Severity("warning")
+)
+
+// Position is a place in a source file, lines and columns counting from 1.
+type Position struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// Range spans the source from the start up to, but not including, the end.
+type Range struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+// Location is a range related to a diagnostic, in the same file unless given.
+type Location struct {
+	File    string `json:"file,omitempty"`
+	Range   Range  `json:"range"`
+	Message string `json:"message"`
+}
+
+// Diagnostic is an error or warning about a range of the source.
+type Diagnostic struct {
+	Code     Code     `json:"code"`
+	Severity Severity `json:"severity"`
+	File     string   `json:"file"`
+	Range    Range    `json:"range"`
+	Message  string   `json:"message"`
+	// what is wrong at the range, in a few words
+	Label string `json:"label,omitempty"`
+	// advice on fixing it, like did you mean suggestions
+	Hints   []string   `json:"hints,omitempty"`
+	Related []Location `json:"related,omitempty"`
+	// the source line of the start of the range
+	SourceLine string `json:"-"`
+}
+
+func (d *Diagnostic) Error() string {
+	return fmt.Sprintf("line %d: %q\n%s", d.Range.Start.Line, strings.TrimSpace(d.SourceLine), d.Message)
+}
+
+// AsDiagnostic returns the diagnostic of an error, or turns errors which are not diagnostics,
+// like failing to read the file, into one about the whole file.
+func AsDiagnostic(err error, filename string) *Diagnostic {
+	var d *Diagnostic
+	if errors.As(err, &d) {
+		return d
+	}
+	return &Diagnostic{
+		Code:     ReadError,
+		Severity: SeverityError,
+		File:     filename,
+		Range:    Range{Start: Position{1, 1}, End: Position{1, 1}},
+		Message:  err.Error(),
+	}
+}
+
+// tokenRange is the range of the token, or of the end of the current line at the end of the file.
+// Its columns count runes, where the tokenizer counts bytes, so they stay right after non-ASCII text.
+func tokenRange(tk *tokenizer.Tokenizer, token tokenizer.Token) Range {
+	if token.Line == 0 {
+		end := Position{tk.LineNr, utf8.RuneCountInString(tk.SourceLine(tk.LineNr)) + 1}
+		return Range{end, end}
+	}
+	line := tk.SourceLine(token.Line)
+	column := token.Column
+	if column-1 <= len(line) {
+		column = utf8.RuneCountInString(line[:column-1]) + 1
+	}
+	start := Position{token.Line, column}
+	return Range{start, Position{token.Line, column + utf8.RuneCountInString(token.Raw)}}
+}
+
+// errorAt reports an error about the token.
+func errorAt(tk *tokenizer.Tokenizer, token tokenizer.Token, code Code, format string, args ...any) *Diagnostic {
+	r := tokenRange(tk, token)
+	return &Diagnostic{
+		Code:       code,
+		Severity:   SeverityError,
+		Range:      r,
+		Message:    fmt.Sprintf(format, args...),
+		SourceLine: tk.SourceLine(r.Start.Line),
+	}
+}
+
+// UnknownTokenError reports a token that is no keyword, symbol, constant or identifier.
+func UnknownTokenError(tk *tokenizer.Tokenizer, token tokenizer.Token) *Diagnostic {
+	return errorAt(tk, token, UnknownToken, "unknown token %q", token.Raw)
+}
+
+func warningAt(tk *tokenizer.Tokenizer, token tokenizer.Token, code Code, format string, args ...any) *Diagnostic {
+	d := errorAt(tk, token, code, format, args...)
+	d.Severity = SeverityWarning
+	return d
+}
+
+func (d *Diagnostic) label(format string, args ...any) *Diagnostic {
+	d.Label = fmt.Sprintf(format, args...)
+	return d
+}
+
+func (d *Diagnostic) hint(format string, args ...any) *Diagnostic {
+	d.Hints = append(d.Hints, fmt.Sprintf(format, args...))
+	return d
+}
+
+// literalError reports an invalid literal, with the hint of the error, if any.
+func literalError(tk *tokenizer.Tokenizer, token tokenizer.Token, err error) *Diagnostic {
+	var hinted *hintedError
+	if errors.As(err, &hinted) {
+		return errorAt(tk, token, InvalidLiteral, "%s", hinted.msg).hint("%s", hinted.hint)
+	}
+	return errorAt(tk, token, InvalidLiteral, "%s", err)
+}
+
+// related adds a location related to the diagnostic, like where something was declared.
+func (d *Diagnostic) related(tk *tokenizer.Tokenizer, token tokenizer.Token, format string, args ...any) *Diagnostic {
+	d.Related = append(d.Related, Location{
+		Range:   tokenRange(tk, token),
+		Message: fmt.Sprintf(format, args...),
+	})
+	return d
+}
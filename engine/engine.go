@@ -2,10 +2,9 @@ package engine
 
 import (
 	"errors"
-	"fmt"
 	"regexp"
+	"strconv"
 
-	"github.com/hlmerscher/jack-compiler-go/logger"
 	"github.com/hlmerscher/jack-compiler-go/tokenizer"
 	"github.com/hlmerscher/jack-compiler-go/vm"
 )
@@ -17,16 +16,30 @@ var (
 	notExpressionDec = errors.New("not an expression declaration")
 )
 
+// tokenMatcher returns the token when it matches, or a description of what it expects otherwise.
 type tokenMatcher func(tokenizer.Token) (string, bool)
 
 func is(tokenTerm string) tokenMatcher {
 	return func(t tokenizer.Token) (string, bool) {
-		return tokenTerm, t.Raw == tokenTerm
+		if t.Raw != tokenTerm {
+			return strconv.Quote(tokenTerm), false
+		}
+		return tokenTerm, true
+	}
+}
+
+// named describes what the matcher expects by a name, instead of listing its tokens.
+func named(name string, matcher tokenMatcher) tokenMatcher {
+	return func(t tokenizer.Token) (string, bool) {
+		if token, ok := matcher(t); ok {
+			return token, ok
+		}
+		return name, false
 	}
 }
 
 func isType() tokenMatcher {
-	return or(is("boolean"), is("int"), is("char"), isIdentifier())
+	return named("a type", or(is("boolean"), is("int"), is("char"), isIdentifier()))
 }
 
 func isOp() tokenMatcher {
@@ -35,7 +48,29 @@ func isOp() tokenMatcher {
 	for i, op := range ops {
 		matchers[i] = is(op)
 	}
-	return or(matchers...)
+	return named("an operator", or(matchers...))
+}
+
+// binding strength of the binary operators, when operator precedence is enabled
+var opPrecedence = map[string]int{
+	"&": 0, "|": 0,
+	"<": 1, ">": 1, "=": 1,
+	"+": 2, "-": 2,
+	"*": 3, "/": 3,
+}
+
+// precedenceMatters tells whether a chain of binary operators, in source order, evaluates
+// differently left to right than with operator precedence, which happens when an operator
+// is followed by one binding tighter.
+func precedenceMatters(ops []string) bool {
+	for i, op := range ops {
+		for _, next := range ops[i+1:] {
+			if opPrecedence[next] > opPrecedence[op] {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 func isUnaryOp() tokenMatcher {
@@ -44,7 +79,7 @@ func isUnaryOp() tokenMatcher {
 	for i, op := range ops {
 		matchers[i] = is(op)
 	}
-	return or(matchers...)
+	return named("a unary operator", or(matchers...))
 }
 
 func isIdentifier() tokenMatcher {
@@ -52,6 +87,9 @@ func isIdentifier() tokenMatcher {
 		matcher := regexp.MustCompile(`^[a-z_A-Z]{1}[a-zA-Z_0-9]*$`)
 		itIs := token.Type == tokenizer.IDENTIFIER &&
 			matcher.Match([]byte(token.Raw))
+		if !itIs {
+			return "an identifier", false
+		}
 
 		return token.Raw, itIs
 	}
@@ -63,8 +101,12 @@ func isTerm() tokenMatcher {
 
 		itIs := token.Type == tokenizer.INT_CONST ||
 			token.Type == tokenizer.STRING_CONST ||
+			token.Type == tokenizer.CHAR_CONST ||
 			token.Type == tokenizer.KEYWORD ||
 			isId
+		if !itIs {
+			return "an expression", false
+		}
 
 		return token.Raw, itIs
 	}
@@ -72,12 +114,15 @@ func isTerm() tokenMatcher {
 
 func or(matchers ...tokenMatcher) tokenMatcher {
 	return func(t tokenizer.Token) (string, bool) {
+		var names []string
 		for _, match := range matchers {
-			if token, ok := match(t); ok {
+			token, ok := match(t)
+			if ok {
 				return token, ok
 			}
+			names = append(names, token)
 		}
-		return t.Raw, false
+		return alternatives(names), false
 	}
 }
 
@@ -94,13 +139,13 @@ func processToken(tk *tokenizer.Tokenizer, matchers ...tokenMatcher) (*tokenizer
 	}
 
 	if expToken == "" {
-		return nil, fmt.Errorf(
-			"wrong token error on line %d\n%q\nexpected %q, got %q",
-			tk.LineNr, tk.CurrentLine, tokenNames, tk.Current.Raw,
-		)
+		return nil, unexpectedToken(tk, tokenNames)
 	}
 
 	token := tk.Current
+	if tk.Consumed != nil {
+		tk.Consumed(token)
+	}
 
 	_, err := tk.Advance()
 	if err != nil {
@@ -110,8 +155,51 @@ func processToken(tk *tokenizer.Tokenizer, matchers ...tokenMatcher) (*tokenizer
 	return &token, nil
 }
 
+// closingSymbols are expected right after the token before, missing them is reported there.
+var closingSymbols = map[string]bool{`";"`: true, `")"`: true, `"]"`: true}
+
+func unexpectedToken(tk *tokenizer.Tokenizer, names []string) *Diagnostic {
+	expected := alternatives(names)
+	d := errorAt(tk, tk.Current, UnexpectedToken, "expected %s, found %s", expected, describe(tk.Current)).
+		label("expected %s", expected)
+
+	previous := tk.Previous
+	if len(names) == 1 && closingSymbols[names[0]] && previous.Line != 0 && previous.Line != tk.Current.Line {
+		end := tokenRange(tk, previous).End
+		d.Range = Range{end, end}
+		d.SourceLine = tk.SourceLine(end.Line)
+		d.label("expected %s after this", expected)
+	}
+	if tk.Current.Type == tokenizer.IDENTIFIER || tk.Current.Type == tokenizer.KEYWORD {
+		d.didYouMean(tk.Current.Raw, quotedNames(expected))
+	}
+	return d
+}
+
+// tokenPanic is the panic of processTokenOrPanics, the only one Recover turns into an error.
+type tokenPanic struct {
+	err error
+}
+
+// processTokenOrPanics panics with the error of an unexpected token, recovered by Recover.
 func processTokenOrPanics(tk *tokenizer.Tokenizer, matchers ...tokenMatcher) *tokenizer.Token {
 	token, err := processToken(tk, matchers...)
-	logger.Error(err)
+	if err != nil {
+		panic(tokenPanic{err})
+	}
 	return token
 }
+
+// Recover turns the panic of an unexpected token into the returned error, deferred by the callers
+// of the compiler. Any other panic, like a bug of the compiler, goes on.
+func Recover(err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	p, ok := r.(tokenPanic)
+	if !ok {
+		panic(r)
+	}
+	*err = p.err
+}
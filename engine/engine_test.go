@@ -0,0 +1,31 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPrecedenceMatters(t *testing.T) {
+	tests := []struct {
+		ops  string
+		want bool
+	}{
+		{"", false},
+		{"+", false},
+		{"+ -", false},
+		{"* +", false},
+		{"+ *", true},
+		{"- /", true},
+		{"< +", true},
+		{"& =", true},
+		{"= &", false},
+		{"* / + - < &", false},
+		{"& | < + *", true},
+		{"* + * ", true},
+	}
+	for _, test := range tests {
+		if got := precedenceMatters(strings.Fields(test.ops)); got != test.want {
+			t.Errorf("precedenceMatters(%s) = %v, want %v", test.ops, got, test.want)
+		}
+	}
+}
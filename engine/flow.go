@@ -0,0 +1,127 @@
+package engine
+
+import (
+	"strconv"
+	"strings"
+)
+
+// flowGraph is the control-flow graph of the vm code of a single subroutine.
+// The last block index (len(blocks)) stands for the end of the subroutine,
+// reaching it means the vm would fall off the end of the function.
+type flowGraph struct {
+	blocks []*flowBlock
+}
+
+type flowBlock struct {
+	instructions []string
+	successors   []int
+}
+
+func newFlowGraph(code string) *flowGraph {
+	var instructions []string
+	for _, line := range strings.Split(code, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			instructions = append(instructions, line)
+		}
+	}
+
+	// leaders are the first instruction of every basic block
+	leaders := map[int]bool{0: true}
+	labels := make(map[string]int)
+	for i, instruction := range instructions {
+		fields := strings.Fields(instruction)
+		switch fields[0] {
+		case "label":
+			leaders[i] = true
+			labels[fields[1]] = i
+		case "goto", "if-goto", "return":
+			leaders[i+1] = true
+		}
+	}
+
+	graph := &flowGraph{}
+	blockAt := make(map[int]int)
+	for i := range instructions {
+		if leaders[i] {
+			blockAt[i] = len(graph.blocks)
+			graph.blocks = append(graph.blocks, &flowBlock{})
+		}
+		block := graph.blocks[len(graph.blocks)-1]
+		block.instructions = append(block.instructions, instructions[i])
+	}
+	end := len(graph.blocks)
+
+	for b, block := range graph.blocks {
+		fallthroughTo := end
+		if b+1 < len(graph.blocks) {
+			fallthroughTo = b + 1
+		}
+
+		last := strings.Fields(block.instructions[len(block.instructions)-1])
+		switch last[0] {
+		case "return":
+		case "goto":
+			block.successors = append(block.successors, blockAt[labels[last[1]]])
+		case "if-goto":
+			target := blockAt[labels[last[1]]]
+			// conditions known at compile time, like while (true), take a single branch
+			cond, known := block.constantCondition()
+			if !known || cond != 0 {
+				block.successors = append(block.successors, target)
+			}
+			if !known || cond == 0 {
+				block.successors = append(block.successors, fallthroughTo)
+			}
+		default:
+			block.successors = append(block.successors, fallthroughTo)
+		}
+	}
+
+	return graph
+}
+
+// constantCondition evaluates the value on top of the stack right before the
+// block's final if-goto, when it is made only of constants.
+func (b *flowBlock) constantCondition() (int16, bool) {
+	var value int16
+	var known bool
+	for _, instruction := range b.instructions[:len(b.instructions)-1] {
+		fields := strings.Fields(instruction)
+		switch {
+		case len(fields) == 3 && fields[0] == "push" && fields[1] == "constant":
+			n, err := strconv.Atoi(fields[2])
+			value, known = int16(n), err == nil
+		case fields[0] == "not" && known:
+			value = ^value
+		case fields[0] == "neg" && known:
+			value = -value
+		default:
+			known = false
+		}
+	}
+	return value, known
+}
+
+func (g *flowGraph) reachesEnd() bool {
+	end := len(g.blocks)
+	if end == 0 {
+		return true
+	}
+
+	visited := make(map[int]bool)
+	queue := []int{0}
+	for len(queue) > 0 {
+		b := queue[0]
+		queue = queue[1:]
+		if b == end {
+			return true
+		}
+		if visited[b] {
+			continue
+		}
+		visited[b] = true
+		queue = append(queue, g.blocks[b].successors...)
+	}
+
+	return false
+}
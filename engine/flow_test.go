@@ -0,0 +1,70 @@
+package engine
+
+import "testing"
+
+func TestReachesEnd(t *testing.T) {
+	tests := []struct {
+		name string
+		code string
+		want bool
+	}{
+		{"empty", "", true},
+		{"return", "push constant 0\nreturn", false},
+		{"no return", "push constant 0\npop temp 0", true},
+		{"if without else", `
+push local 0
+if-goto IF_0
+goto IF_1
+label IF_0
+push constant 0
+return
+label IF_1`, true},
+		{"if and else return", `
+push local 0
+if-goto IF_0
+push constant 1
+return
+label IF_0
+push constant 0
+return`, false},
+		{"while true", `
+label WHILE_0
+push constant 0
+not
+not
+if-goto WHILE_1
+goto WHILE_0
+label WHILE_1`, false},
+		{"while false", `
+label WHILE_0
+push constant 0
+not
+if-goto WHILE_1
+goto WHILE_0
+label WHILE_1`, true},
+		{"while on a variable", `
+label WHILE_0
+push local 0
+not
+if-goto WHILE_1
+goto WHILE_0
+label WHILE_1`, true},
+		{"return after the loop", `
+label WHILE_0
+push local 0
+not
+if-goto WHILE_1
+goto WHILE_0
+label WHILE_1
+push constant 0
+return`, false},
+		{"code after return", "push constant 0\nreturn\npush constant 1\npop temp 0", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := newFlowGraph(test.code).reachesEnd(); got != test.want {
+				t.Errorf("reachesEnd() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package engine
+
+import (
+	"fmt"
+	"strconv"
+)
+
+const maxIntConstant = 32767
+
+// codes of the escape sequences in the hack character set, newline and backspace are hack specific
+var escapeCodes = map[rune]int{
+	'n':  128,
+	'b':  129,
+	'"':  '"',
+	'\'': '\'',
+	'\\': '\\',
+}
+
+// literalChars returns the character codes of a quoted string constant or char literal.
+func literalChars(raw string, withEscapes bool) ([]int, error) {
+	if len(raw) < 2 || raw[len(raw)-1] != raw[0] {
+		return nil, fmt.Errorf("unterminated literal %s", raw)
+	}
+	content := raw[1 : len(raw)-1]
+
+	chars := make([]int, 0, len(content))
+	escaped := false
+	for _, char := range content {
+		if escaped {
+			code, ok := escapeCodes[char]
+			if !ok {
+				return nil, fmt.Errorf("unknown escape sequence \\%c in %s", char, raw)
+			}
+			chars = append(chars, code)
+			escaped = false
+			continue
+		}
+		if withEscapes && char == '\\' {
+			escaped = true
+			continue
+		}
+		// a string constant holds no quotes, but a char literal may be one
+		if char == '"' && raw[0] == '"' {
+			continue
+		}
+		chars = append(chars, int(char))
+	}
+	if escaped {
+		return nil, fmt.Errorf("unterminated escape sequence in %s", raw)
+	}
+
+	return chars, nil
+}
+
+func charLiteral(raw string) (int, error) {
+	chars, err := literalChars(raw, true)
+	if err != nil {
+		return 0, err
+	}
+	if len(chars) != 1 {
+		return 0, fmt.Errorf("char literal %s must hold a single character", raw)
+	}
+	return chars[0], nil
+}
+
+// intConstant parses an integer constant, which must fit in the positive range of a 16 bit word.
+func intConstant(raw string) (int, error) {
+	n, err := strconv.Atoi(raw)
+	if err == nil && n <= maxIntConstant {
+		return n, nil
+	}
+
+	hint := "it does not fit in a 16 bit word, split it in smaller values, e.g. a high and a low word"
+	switch {
+	case err == nil && n == maxIntConstant+1:
+		hint = fmt.Sprintf("to get the same 16 bit pattern use (-%d - 1), -%d is out of range too", maxIntConstant, maxIntConstant+1)
+	case err == nil && n <= 65535:
+		hint = fmt.Sprintf("to get the same 16 bit pattern use -%d", 65536-n)
+	}
+	return 0, &hintedError{fmt.Sprintf("integer constant %s out of range 0..%d", raw, maxIntConstant), hint}
+}
+
+// hintedError is an error with advice on fixing it.
+type hintedError struct {
+	msg  string
+	hint string
+}
+
+func (e *hintedError) Error() string {
+	return e.msg + "\nhint: " + e.hint
+}
+
+// isHackChar tells whether the code is printable in the hack character set,
+// the ascii printable characters plus newline (128) and backspace (129).
+// Codes 130 to 152 are keys that can be read from the keyboard, but not printed.
+func isHackChar(code int) bool {
+	return code >= 32 && code <= 126 || code == 128 || code == 129
+}
+
+func checkHackChars(raw string, chars []int) error {
+	for _, char := range chars {
+		if !isHackChar(char) {
+			return fmt.Errorf("character %q (code %d) in %s is not in the hack character set", rune(char), char, raw)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package engine
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestIntConstant(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want int
+		hint string
+	}{
+		{raw: "0", want: 0},
+		{raw: "32767", want: 32767},
+		{raw: "32768", hint: "to get the same 16 bit pattern use (-32767 - 1), -32768 is out of range too"},
+		{raw: "32769", hint: "to get the same 16 bit pattern use -32767"},
+		{raw: "65535", hint: "to get the same 16 bit pattern use -1"},
+		{raw: "65536", hint: "it does not fit in a 16 bit word, split it in smaller values, e.g. a high and a low word"},
+		{raw: "99999999999999999999", hint: "it does not fit in a 16 bit word, split it in smaller values, e.g. a high and a low word"},
+	}
+	for _, test := range tests {
+		t.Run(test.raw, func(t *testing.T) {
+			got, err := intConstant(test.raw)
+			if test.hint == "" {
+				if err != nil || got != test.want {
+					t.Errorf("intConstant(%s) = %d, %v, want %d", test.raw, got, err, test.want)
+				}
+				return
+			}
+			var hinted *hintedError
+			if !errors.As(err, &hinted) {
+				t.Fatalf("intConstant(%s) = %d, %v, want an error with a hint", test.raw, got, err)
+			}
+			if hinted.hint != test.hint {
+				t.Errorf("hint %q, want %q", hinted.hint, test.hint)
+			}
+		})
+	}
+}
+
+func TestLiteralChars(t *testing.T) {
+	tests := []struct {
+		raw         string
+		withEscapes bool
+		want        []int
+		wantErr     bool
+	}{
+		{raw: `""`, want: []int{}},
+		{raw: `"ab"`, want: []int{'a', 'b'}},
+		{raw: `"a\n"`, want: []int{'a', '\\', 'n'}},
+		{raw: `"a\n"`, withEscapes: true, want: []int{'a', 128}},
+		{raw: `"\b\\\"\'"`, withEscapes: true, want: []int{129, '\\', '"', '\''}},
+		{raw: `'"'`, withEscapes: true, want: []int{'"'}},
+		{raw: `'\''`, withEscapes: true, want: []int{'\''}},
+		{raw: `"\q"`, withEscapes: true, wantErr: true},
+		{raw: `"a\"`, withEscapes: true, wantErr: true},
+		{raw: `"a`, wantErr: true},
+		{raw: `"`, wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := literalChars(test.raw, test.withEscapes)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("literalChars(%s, %v) = %v, want an error", test.raw, test.withEscapes, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("literalChars(%s, %v) = %v, %v, want %v", test.raw, test.withEscapes, got, err, test.want)
+		}
+	}
+}
+
+func TestCharLiteral(t *testing.T) {
+	if got, err := charLiteral(`'a'`); err != nil || got != 'a' {
+		t.Errorf("charLiteral('a') = %d, %v, want %d", got, err, 'a')
+	}
+	for _, raw := range []string{`''`, `'ab'`} {
+		if got, err := charLiteral(raw); err == nil {
+			t.Errorf("charLiteral(%s) = %d, want an error", raw, got)
+		}
+	}
+}
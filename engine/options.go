@@ -0,0 +1,100 @@
+package engine
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+type Extension string
+
+const (
+	// 'a' char literals and \n, \b, \", \' and \\ escapes in string constants
+	CharLiterals = Extension("chars")
+	// for (init; cond; step) { } loops, and break and continue inside while and for loops
+	Loops = Extension("loops")
+	// * and / bind tighter than + and -, which bind tighter than comparisons, then & and |
+	Precedence = Extension("precedence")
+	// & and | between boolean operands skip the right operand once the result is known
+	ShortCircuit = Extension("shortcircuit")
+	// else followed directly by if, and switch (expression) { case 1: ... default: ... } statements
+	Branches = Extension("branches")
+	// const int WIDTH = 512; class level declarations, usable from other classes as Class.WIDTH
+	Constants = Extension("consts")
+	// let x += e; with -=, *= and /=, and let x++; and let x--;
+	CompoundAssignment = Extension("compound")
+	// [1, 2, 3] array literals, and adjacent string constants concatenated, also across lines
+	Literals = Extension("literals")
+)
+
+var extensions = []Extension{
+	CharLiterals,
+	Loops,
+	Precedence,
+	ShortCircuit,
+	Branches,
+	Constants,
+	CompoundAssignment,
+	Literals,
+}
+
+type Options struct {
+	Extensions map[Extension]bool
+	// warns about expressions depending on the evaluation order of binary operators
+	Strict bool
+	// constants declared by the classes of the project, by class and constant name
+	Constants map[string]map[string]Constant
+	// string constants are created once per class and kept in statics, so they must not be mutated
+	InternStrings bool
+	// marks the vm code of every statement with a // line N comment, N being its line in the jack source
+	LineComments bool
+	// descriptors of precompiled classes by class name, calls into them are checked against
+	Defs map[string]ClassDef
+}
+
+// Constant is a class level constant, with the type it is declared with.
+type Constant struct {
+	Type  string `json:"type"`
+	Value int    `json:"value"`
+}
+
+func (o Options) Has(ext Extension) bool {
+	return o.Extensions[ext]
+}
+
+// WithoutProject returns the options set by the user, leaving out what is gathered from the project sources.
+func (o Options) WithoutProject() Options {
+	o.Constants = nil
+	return o
+}
+
+// ParseExtensions parses a comma separated list of extension names, like "chars,for".
+func ParseExtensions(list string) (map[Extension]bool, error) {
+	enabled := make(map[Extension]bool)
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !isExtension(Extension(name)) {
+			return nil, fmt.Errorf("unknown language extension %q, available: %s", name, ExtensionNames())
+		}
+		enabled[Extension(name)] = true
+	}
+	return enabled, nil
+}
+
+func ExtensionNames() string {
+	names := make([]string, len(extensions))
+	for i, ext := range extensions {
+		names[i] = string(ext)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func isExtension(ext Extension) bool {
+	return slices.Contains(extensions, ext)
+}
@@ -0,0 +1,46 @@
+package engine
+
+import (
+	"sort"
+
+	"github.com/hlmerscher/jack-compiler-go/symbols"
+)
+
+// Signature describes a subroutine as seen by the other classes.
+type Signature struct {
+	Kind       string   `json:"kind"`
+	Name       string   `json:"name"`
+	ReturnType string   `json:"returnType"`
+	Params     []string `json:"params"`
+}
+
+func (c *Compiler) Signatures() []Signature {
+	return c.signatures
+}
+
+// References returns the other classes the class calls into or reads constants from, sorted by name.
+func (c *Compiler) References() []string {
+	references := make([]string, 0, len(c.references))
+	for className := range c.references {
+		references = append(references, className)
+	}
+	sort.Strings(references)
+	return references
+}
+
+func (c *Compiler) reference(className string) {
+	if className != c.symbols.ClassName() {
+		c.references[className] = true
+	}
+}
+
+// paramTypes returns the types of the parameters in the subroutine scope, in declaration order.
+func paramTypes(scope *symbols.Scope) []string {
+	params := make([]string, 0)
+	for _, symbol := range scope.Symbols() {
+		if symbol.Kind == symbols.Arg {
+			params = append(params, symbol.Type)
+		}
+	}
+	return params
+}
@@ -0,0 +1,111 @@
+package engine
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hlmerscher/jack-compiler-go/tokenizer"
+)
+
+// editDistance is the number of characters inserted, deleted, replaced or swapped with
+// the next one to turn a into b.
+func editDistance(a, b string) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = minOf(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = minOf(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
+
+func minOf(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
+
+// closest returns the candidate closest to the word, when it is close enough to be a typo of it,
+// about one mistake every three characters.
+func closest(word string, candidates []string) (string, bool) {
+	sorted := append([]string{}, candidates...)
+	sort.Strings(sorted)
+
+	best, bestDistance := "", minOf(len(word)/3, len(word)-1)
+	if bestDistance < 1 && len(word) > 1 {
+		bestDistance = 1
+	}
+	for _, candidate := range sorted {
+		if candidate == word {
+			continue
+		}
+		if d := editDistance(word, candidate); d <= bestDistance && (best == "" || d < editDistance(word, best)) {
+			best = candidate
+		}
+	}
+	return best, best != ""
+}
+
+// didYouMean adds a hint with the candidate closest to the token, if any.
+func (d *Diagnostic) didYouMean(word string, candidates []string) *Diagnostic {
+	if suggestion, ok := closest(word, candidates); ok {
+		d.hint("did you mean %q?", suggestion)
+	}
+	return d
+}
+
+// describe names a token in messages, like keyword "let" or end of file.
+func describe(token tokenizer.Token) string {
+	switch token.Type {
+	case "":
+		return "end of file"
+	case tokenizer.KEYWORD, tokenizer.IDENTIFIER:
+		return fmt.Sprintf("%s %q", token.Type, token.Raw)
+	case tokenizer.INT_CONST:
+		return "integer " + token.Raw
+	case tokenizer.STRING_CONST:
+		return "string " + token.Raw
+	case tokenizer.CHAR_CONST:
+		return "char " + token.Raw
+	}
+	return fmt.Sprintf("%q", token.Raw)
+}
+
+// alternatives joins what was expected, like "static", "field" or "}".
+func alternatives(names []string) string {
+	if len(names) == 1 {
+		return names[0]
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " or " + names[len(names)-1]
+}
+
+var quotedPattern = regexp.MustCompile(`"([^"]+)"`)
+
+// quotedNames are the literal tokens among what was expected, without their quotes.
+func quotedNames(expected string) []string {
+	var quoted []string
+	for _, match := range quotedPattern.FindAllStringSubmatch(expected, -1) {
+		quoted = append(quoted, match[1])
+	}
+	return quoted
+}
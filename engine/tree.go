@@ -0,0 +1,78 @@
+package engine
+
+import (
+	"strings"
+
+	"github.com/hlmerscher/jack-compiler-go/tokenizer"
+)
+
+// syntaxTree writes the parse tree of the class being compiled as xml, in the format of the
+// nand2tetris syntax analyzer, with an element per grammar rule enclosing the tokens consumed.
+type syntaxTree struct {
+	out *strings.Builder
+	// rules currently open, empty for the ones left out
+	rules []string
+	// rules to leave out the next time they open, in order
+	skip []string
+}
+
+func (t *syntaxTree) open(rule string) {
+	if len(t.skip) > 0 && t.skip[0] == rule {
+		t.skip = t.skip[1:]
+		t.rules = append(t.rules, "")
+		return
+	}
+	t.indent()
+	t.out.WriteString("<" + rule + ">\n")
+	t.rules = append(t.rules, rule)
+}
+
+func (t *syntaxTree) close() {
+	rule := t.rules[len(t.rules)-1]
+	t.rules = t.rules[:len(t.rules)-1]
+	if rule == "" {
+		return
+	}
+	t.indent()
+	t.out.WriteString("</" + rule + ">\n")
+}
+
+func (t *syntaxTree) token(token tokenizer.Token) {
+	t.indent()
+	t.out.WriteString(token.XML() + "\n")
+}
+
+func (t *syntaxTree) indent() {
+	depth := 0
+	for _, rule := range t.rules {
+		if rule != "" {
+			depth++
+		}
+	}
+	t.out.WriteString(strings.Repeat("  ", depth))
+}
+
+// WriteSyntaxTree makes the compiler write the parse tree of the class to out as well.
+func (c *Compiler) WriteSyntaxTree(out *strings.Builder) {
+	c.tree = &syntaxTree{out: out}
+}
+
+func (c *Compiler) open(rule string) {
+	if c.tree != nil {
+		c.tree.open(rule)
+	}
+}
+
+func (c *Compiler) close() {
+	if c.tree != nil {
+		c.tree.close()
+	}
+}
+
+// leaveOut drops the next rules opened from the tree, when the grammar has no element for them.
+// Without rules, it forgets the ones not opened yet.
+func (c *Compiler) leaveOut(rules ...string) {
+	if c.tree != nil {
+		c.tree.skip = rules
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/hlmerscher/jack-compiler-go/analyzer"
+	"github.com/hlmerscher/jack-compiler-go/jackos"
+)
+
+// source is a jack file to compile, and where its vm code goes.
+type source struct {
+	filename       string
+	outputFilename string
+	// the directory given the file was found in, or the directory of a file given, its classes
+	// are the ones calls are checked against, empty for the OS classes seen from every root
+	root string
+	// an OS class bundled with the compiler, instead of a file
+	embedded bool
+}
+
+// newSource places the vm file next to the jack file, or when an output directory is given,
+// at the same path relative to root within the output directory.
+func newSource(filename, root string) source {
+	if root == "" {
+		root = filepath.Dir(filename)
+	}
+	outputFilename := strings.TrimSuffix(filename, ".jack") + ".vm"
+	if outputDir != "" {
+		rel, err := filepath.Rel(root, outputFilename)
+		if err != nil {
+			rel = filepath.Base(outputFilename)
+		}
+		outputFilename = filepath.Join(outputDir, rel)
+	}
+
+	return source{filename: filename, outputFilename: outputFilename, root: root}
+}
+
+// newOSSource places the vm file of an OS class in the output directory, or when none is
+// given, in the root directory with the vm files of the program.
+func newOSSource(className, root string) source {
+	dirname := outputDir
+	if dirname == "" {
+		dirname = root
+	}
+	return source{
+		filename:       path.Join("os", className+".jack"),
+		outputFilename: filepath.Join(dirname, className+".vm"),
+		embedded:       true,
+	}
+}
+
+// embeddedFile is the source of an OS class, named after its place among the sources.
+type embeddedFile struct {
+	fs.File
+	name string
+}
+
+func (f embeddedFile) Name() string {
+	return f.name
+}
+
+// sourceFile is a source opened for reading.
+type sourceFile interface {
+	analyzer.File
+	io.Closer
+}
+
+func (s source) open() (sourceFile, error) {
+	if !s.embedded {
+		file, err := os.Open(s.filename)
+		if err != nil {
+			return nil, err
+		}
+		return file, nil
+	}
+	file, err := jackos.Open(strings.TrimSuffix(path.Base(s.filename), ".jack"))
+	if err != nil {
+		return nil, err
+	}
+	return embeddedFile{file, s.filename}, nil
+}
+
+func (s source) read() ([]byte, error) {
+	file, err := s.open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// dirFilenames walks the directory recursively, in lexical order, collecting the files with the
+// extension matching the include globs, if any, and none of the exclude globs.
+func dirFilenames(dirname, ext string, include, exclude []string) ([]string, error) {
+	filenames := make([]string, 0)
+	err := filepath.WalkDir(dirname, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dirname, name)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if entry.IsDir() {
+			if rel != "." && matchAny(exclude, rel) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(name) != ext || matchAny(exclude, rel) {
+			return nil
+		}
+		if len(include) > 0 && !matchAny(include, rel) {
+			return nil
+		}
+		filenames = append(filenames, name)
+
+		return nil
+	})
+
+	return filenames, err
+}
+
+func matchAny(globs []string, rel string) bool {
+	for _, glob := range globs {
+		if matchGlob(glob, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob matches a slash separated path against a glob, where ** matches any number of
+// directories. Globs without a slash match the base name only, like in .gitignore files.
+func matchGlob(glob, rel string) bool {
+	if !strings.Contains(glob, "/") {
+		ok, _ := path.Match(glob, path.Base(rel))
+		return ok
+	}
+	return matchSegments(strings.Split(glob, "/"), strings.Split(rel, "/"))
+}
+
+func matchSegments(globs, segments []string) bool {
+	if len(globs) == 0 {
+		return len(segments) == 0
+	}
+	if globs[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchSegments(globs[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(globs[0], segments[0]); !ok {
+		return false
+	}
+	return matchSegments(globs[1:], segments[1:])
+}
+
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func writeToFile(outputFilename string, content string) error {
+	if err := os.MkdirAll(filepath.Dir(outputFilename), 0777); err != nil {
+		return err
+	}
+	return os.WriteFile(outputFilename, []byte(content), 0666)
+}
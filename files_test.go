@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		glob, rel string
+		want      bool
+	}{
+		{"*.jack", "Main.jack", true},
+		{"*.jack", "src/game/Main.jack", true},
+		{"Main.jack", "src/Main.jack", true},
+		{"*Test.jack", "src/CalcTest.jack", true},
+		{"*Test.jack", "src/Calc.jack", false},
+		{"test/*", "test/Main.jack", true},
+		{"test/*", "test/unit/Main.jack", false},
+		{"test/*", "src/test/Main.jack", false},
+		{"test/**", "test/unit/Main.jack", true},
+		{"test/**", "test", true},
+		{"**/test/*.jack", "test/Main.jack", true},
+		{"**/test/*.jack", "src/game/test/Main.jack", true},
+		{"**/test/*.jack", "src/game/test/unit/Main.jack", false},
+		{"src/**/*.jack", "src/Main.jack", true},
+		{"src/**/*.jack", "src/a/b/Main.jack", true},
+		{"src/**/*.jack", "lib/a/Main.jack", false},
+		{"src/[A-M]*.jack", "src/Main.jack", true},
+		{"src/[A-M]*.jack", "src/Sys.jack", false},
+	}
+	for _, test := range tests {
+		if got := matchGlob(test.glob, test.rel); got != test.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", test.glob, test.rel, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/hlmerscher/jack-compiler-go/formatter"
+	"github.com/hlmerscher/jack-compiler-go/logger"
+)
+
+func fmtCommand(args []string) int {
+	var write, list bool
+	flags := newFlagSet("fmt", "<files or directories>")
+	cf := &compileFlags{}
+	cf.addDiscoveryFlags(flags)
+	flags.BoolVar(&write, "w", false, "write the formatted source back to the files instead of the standard output")
+	flags.BoolVar(&list, "l", false, "list the files whose formatting differs, instead of printing them")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	if flags.NArg() == 0 {
+		return usageError(flags, "no files or directories given")
+	}
+
+	sources, err := cf.sources(flags.Args())
+	if err != nil {
+		return sourcesError(flags, err)
+	}
+	var failed int
+	for _, src := range sources {
+		content, err := os.ReadFile(src.filename)
+		if err != nil {
+			failed++
+			logger.Fail("", err)
+			continue
+		}
+		formatted := formatter.Format(string(content))
+		changed := formatted != string(content)
+
+		if list && changed {
+			fmt.Println(src.filename)
+		}
+		if write && changed {
+			if err := os.WriteFile(src.filename, []byte(formatted), 0666); err != nil {
+				failed++
+				logger.Fail("", err)
+			}
+		}
+		if !list && !write {
+			fmt.Print(formatted)
+		}
+	}
+	if failed > 0 {
+		return exitFailed
+	}
+	return exitOK
+}
@@ -0,0 +1,102 @@
+package formatter
+
+import (
+	"strings"
+)
+
+const indentation = "    "
+
+// Format reindents jack source code by the nesting of its braces, trims trailing
+// whitespace, collapses consecutive blank lines and ends the file with a newline.
+func Format(source string) string {
+	var out strings.Builder
+	var depth int
+	var inComment, blank bool
+
+	source = strings.ReplaceAll(source, "\r\n", "\n")
+	for _, line := range strings.Split(source, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			blank = out.Len() > 0
+			continue
+		}
+		if blank {
+			out.WriteString("\n")
+			blank = false
+		}
+
+		if inComment {
+			// continuation lines of block comments line their stars up with the opening one
+			if strings.HasPrefix(line, "*") {
+				line = " " + line
+			}
+			out.WriteString(strings.Repeat(indentation, depth) + line + "\n")
+			inComment = !strings.Contains(line, "*/")
+			continue
+		}
+
+		opened, closed, leading, commentOpen := scan(line)
+		lineDepth := depth - leading
+		if lineDepth < 0 {
+			lineDepth = 0
+		}
+		out.WriteString(strings.Repeat(indentation, lineDepth) + line + "\n")
+
+		depth += opened - closed
+		if depth < 0 {
+			depth = 0
+		}
+		inComment = commentOpen
+	}
+
+	return out.String()
+}
+
+// scan counts the braces of a line outside of strings, char literals and comments, the closing
+// braces before any other code, and whether the line ends within a block comment.
+func scan(line string) (opened, closed, leading int, commentOpen bool) {
+	var inString, inChar, inComment, code bool
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case inComment:
+			if c == '*' && i+1 < len(line) && line[i+1] == '/' {
+				inComment = false
+				i++
+			}
+		case inString:
+			if c == '"' {
+				inString = false
+			}
+		case inChar:
+			// char literals come with the escapes of the chars extension, like '\''
+			if c == '\\' {
+				i++
+			} else if c == '\'' {
+				inChar = false
+			}
+		case c == '/' && i+1 < len(line) && line[i+1] == '/':
+			return opened, closed, leading, false
+		case c == '/' && i+1 < len(line) && line[i+1] == '*':
+			inComment = true
+			i++
+		case c == '"':
+			inString, code = true, true
+		case c == '\'':
+			inChar, code = true, true
+		case c == '{':
+			opened++
+			code = true
+		case c == '}':
+			closed++
+			if !code {
+				leading++
+			}
+		default:
+			if c != ' ' && c != '\t' {
+				code = true
+			}
+		}
+	}
+	return opened, closed, leading, inComment
+}
@@ -0,0 +1,34 @@
+package formatter
+
+import "testing"
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{
+			name:   "nested blocks",
+			source: "class Main {\nfunction void main() {\nreturn;\n}\n}\n",
+			want:   "class Main {\n    function void main() {\n        return;\n    }\n}\n",
+		},
+		{
+			name:   "braces in strings",
+			source: "class Main {\nfunction void main() {\ndo Output.printString(\"{\");\nreturn;\n}\n}\n",
+			want:   "class Main {\n    function void main() {\n        do Output.printString(\"{\");\n        return;\n    }\n}\n",
+		},
+		{
+			name:   "braces in char literals",
+			source: "class Main {\nfunction char main() {\nvar char c;\nlet c = '{';\nlet c = '\\'';\nreturn '}';\n}\n}\n",
+			want:   "class Main {\n    function char main() {\n        var char c;\n        let c = '{';\n        let c = '\\'';\n        return '}';\n    }\n}\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Format(test.source); got != test.want {
+				t.Errorf("got\n%s\nwant\n%s", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hlmerscher/jack-compiler-go/analyzer"
+	"github.com/hlmerscher/jack-compiler-go/engine"
+	"github.com/hlmerscher/jack-compiler-go/logger"
+)
+
+func tokensCommand(args []string) int {
+	return inspectCommand("tokens", "T.xml", analyzer.Tokens, args)
+}
+
+func astCommand(args []string) int {
+	return inspectCommand("ast", ".xml", analyzer.SyntaxTree, args)
+}
+
+func symbolsCommand(args []string) int {
+	return inspectCommand("symbols", ".symbols", analyzer.Symbols, args)
+}
+
+// inspectCommand prints what the inspect function writes about each file, or with -w writes it
+// next to the file, named after it with the suffix, like the nand2tetris comparison files.
+func inspectCommand(name, suffix string, inspect func(analyzer.File, *strings.Builder, engine.Options) error, args []string) int {
+	var write bool
+	flags := newFlagSet(name, "<files or directories>")
+	cf := addCompileFlags(flags)
+	flags.BoolVar(&write, "w", false, "write the xml next to each file, as Xxx"+suffix+", instead of the standard output")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	if flags.NArg() == 0 {
+		return usageError(flags, "no files or directories given")
+	}
+	if err := cf.apply(); err != nil {
+		return usageError(flags, err.Error())
+	}
+
+	var failed int
+	sources, err := cf.discover(flags.Args())
+	if err != nil {
+		return sourcesError(flags, err)
+	}
+	for _, src := range sources {
+		sourceFile, err := src.open()
+		if err != nil {
+			failed++
+			report(src.filename, nil, fmt.Errorf("error opening file\n%w", err))
+			continue
+		}
+		out := new(strings.Builder)
+		err = inspect(sourceFile, out, opts)
+		sourceFile.Close()
+		if err != nil {
+			failed++
+			report(src.filename, nil, err)
+			continue
+		}
+
+		if !write {
+			fmt.Print(out.String())
+			continue
+		}
+		outputFilename := strings.TrimSuffix(src.filename, ".jack") + suffix
+		if err := writeToFile(outputFilename, out.String()); err != nil {
+			failed++
+			logger.Fail(src.filename+":\n", err)
+		}
+	}
+	flushDiagnostics()
+	if failed > 0 {
+		return exitFailed
+	}
+	return exitOK
+}
@@ -0,0 +1,56 @@
+// Package jackos bundles the Jack OS, the classes every Jack program can call into: the Jack
+// sources, and their descriptors, written by jack build -jackdef from the sources.
+package jackos
+
+import (
+	"embed"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/hlmerscher/jack-compiler-go/engine"
+)
+
+var (
+	//go:embed src/*.jack
+	sources embed.FS
+	//go:embed defs/*.jackdef
+	defs embed.FS
+)
+
+// Classes returns the names of the OS classes, sorted.
+func Classes() []string {
+	entries, _ := fs.ReadDir(sources, "src")
+	classes := make([]string, len(entries))
+	for i, entry := range entries {
+		classes[i] = strings.TrimSuffix(entry.Name(), ".jack")
+	}
+	return classes
+}
+
+// Open opens the Jack source of an OS class.
+func Open(className string) (fs.File, error) {
+	return sources.Open(path.Join("src", className+".jack"))
+}
+
+// Defs returns the descriptors of the OS classes, by class name.
+func Defs() (map[string]engine.ClassDef, error) {
+	entries, err := fs.ReadDir(defs, "defs")
+	if err != nil {
+		return nil, err
+	}
+	classDefs := make(map[string]engine.ClassDef)
+	for _, entry := range entries {
+		file, err := defs.Open(path.Join("defs", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		def, err := engine.ReadClassDef(file)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+		classDefs[def.Class] = def
+	}
+	return classDefs, nil
+}
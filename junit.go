@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"time"
+)
+
+// JUnit XML report, as read by CI servers
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Errors   int             `xml:"errors,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	File      string        `xml:"file,attr,omitempty"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Error     *junitFailure `xml:"error,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// writeJUnit writes the results as a JUnit XML report, a test suite per test class.
+func writeJUnit(filename string, results []testResult) error {
+	report := junitTestSuites{}
+	var total time.Duration
+	var suiteTimes []time.Duration
+	for _, result := range results {
+		if len(report.Suites) == 0 || report.Suites[len(report.Suites)-1].Name != result.class.name {
+			report.Suites = append(report.Suites, junitTestSuite{Name: result.class.name})
+			suiteTimes = append(suiteTimes, 0)
+		}
+		suite := &report.Suites[len(report.Suites)-1]
+
+		testCase := junitTestCase{
+			Name:      result.function[len(result.class.name)+1:],
+			Classname: result.class.name,
+			File:      result.class.comp.filename,
+			Time:      seconds(result.duration),
+		}
+		failure := &junitFailure{Message: result.failure, Text: result.message()}
+		switch {
+		case result.failure == "":
+		case result.crashed:
+			failure.Type = "runtime"
+			testCase.Error = failure
+			suite.Errors++
+			report.Errors++
+		default:
+			failure.Type = "assertion"
+			testCase.Failure = failure
+			suite.Failures++
+			report.Failures++
+		}
+		suite.Cases = append(suite.Cases, testCase)
+		suite.Tests++
+		report.Tests++
+		suiteTimes[len(suiteTimes)-1] += result.duration
+		total += result.duration
+	}
+	for i := range report.Suites {
+		report.Suites[i].Time = seconds(suiteTimes[i])
+	}
+	report.Time = seconds(total)
+
+	content, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeToFile(filename, xml.Header+string(content)+"\n")
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
@@ -35,10 +35,19 @@ func Println(values ...any) {
 	fmt.Println(values...)
 }
 
+func Warn(msg string) {
+	log.Printf("\nWARNING: %s", msg)
+}
+
 func Error(err error) {
 	Errorf("", err)
 }
 
+// Fail reports an error without exiting.
+func Fail(msg string, err error) {
+	log.Printf("\n%s%s", msg, err)
+}
+
 func Errorf(msg string, err any) {
 	if err != nil {
 		log.Fatalf("\n%s%s", msg, err)
@@ -1,80 +1,227 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"io/fs"
 	"os"
 	"strings"
 
-	"github.com/hlmerscher/jack-compiler-go/analyzer"
+	"github.com/hlmerscher/jack-compiler-go/engine"
 	"github.com/hlmerscher/jack-compiler-go/logger"
 )
 
-func main() {
-	var filename, dirname string
-	var verbose bool
-	flag.StringVar(&filename, "f", "", "the filename of the vm source file")
-	flag.StringVar(&dirname, "d", "", "the directory of the vm source files")
-	flag.BoolVar(&verbose, "v", false, "verbose output")
-	flag.Parse()
-	if filename == "" && dirname == "" {
-		panic("filename/directory is missing")
-	}
-	logger.Toggle(verbose)
-
-	if filename != "" {
-		analyzeFile(filename)
-	}
-	if dirname != "" {
-		dirname = strings.TrimSuffix(dirname, "/")
-		for _, filename := range dirFilenames(dirname) {
-			analyzeFile(filename)
-		}
+// exit codes
+const (
+	exitOK     = 0
+	exitFailed = 1
+	exitUsage  = 2
+)
+
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"build", "compile jack files into vm files", buildCommand},
+		{"check", "report the errors and warnings of jack files, without writing any files", checkCommand},
+		{"tokens", "print the tokens of jack files as xml", tokensCommand},
+		{"ast", "print the parse tree of jack files as xml", astCommand},
+		{"symbols", "print the symbol tables of jack files, the class scope and every subroutine scope", symbolsCommand},
+		{"fmt", "format jack files", fmtCommand},
+		{"run", "run jack or vm files in the vm emulator", runCommand},
+		{"test", "run the test functions of the *Test.jack classes in the vm emulator", testCommand},
+		{"script", "run nand2tetris .tst test scripts in the vm emulator, comparing their output with the .cmp files", scriptCommand},
+		{"help", "show the help of a command", helpCommand},
 	}
 }
 
-func analyzeFile(filename string) {
-	fmt.Printf("input:\t%s\n", filename)
+func main() {
+	os.Exit(dispatch(os.Args[1:]))
+}
 
-	sourceFile := openJackFile(filename)
-	defer sourceFile.Close()
+func dispatch(args []string) int {
+	if len(args) == 0 {
+		usage(os.Stderr)
+		return exitUsage
+	}
+	// flags without a command build, like before there were commands
+	if strings.HasPrefix(args[0], "-") && args[0] != "-h" && args[0] != "-help" && args[0] != "--help" {
+		return buildCommand(args)
+	}
+	if cmd, ok := findCommand(args[0]); ok {
+		return cmd.run(args[1:])
+	}
+	if args[0] == "-h" || args[0] == "-help" || args[0] == "--help" {
+		usage(os.Stdout)
+		return exitOK
+	}
+	fmt.Fprintf(os.Stderr, "jack: unknown command %q\n\n", args[0])
+	usage(os.Stderr)
+	return exitUsage
+}
 
-	out := new(strings.Builder)
-	err := analyzer.Compile(sourceFile, out)
-	if err != nil {
-		logger.Error(err)
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+func usage(out *os.File) {
+	fmt.Fprintf(out, "usage: jack <command> [flags] [files or directories]\n\ncommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %-9s%s\n", cmd.name, cmd.summary)
 	}
-	writeToFile(filename, out.String())
+	fmt.Fprintf(out, "\nRun \"jack help <command>\" for the flags of a command.\n")
 }
 
-func openJackFile(filename string) *os.File {
-	inputFile, err := os.Open(filename)
-	logger.Errorf("error opening file\n", err)
-	return inputFile
+func helpCommand(args []string) int {
+	if len(args) == 0 {
+		usage(os.Stdout)
+		return exitOK
+	}
+	cmd, ok := findCommand(args[0])
+	if !ok || cmd.name == "help" {
+		fmt.Fprintf(os.Stderr, "jack: unknown command %q\n", args[0])
+		return exitUsage
+	}
+	return cmd.run([]string{"-h"})
 }
 
-func dirFilenames(dirname string) []string {
-	entries, err := os.ReadDir(dirname)
-	logger.Errorf("error reading directory\n", err)
+// newFlagSet creates the flags of a command, whose usage describes its arguments.
+func newFlagSet(name, arguments string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {
+		cmd, _ := findCommand(name)
+		out := flags.Output()
+		fmt.Fprintf(out, "usage: jack %s [flags] %s\n\n%s\n\nflags:\n", name, arguments, cmd.summary)
+		flags.PrintDefaults()
+	}
+	return flags
+}
 
-	filenames := make([]string, 0)
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
+// parseFlags parses the arguments of a command, returning the exit code when it must not go on.
+func parseFlags(flags *flag.FlagSet, args []string) (int, bool) {
+	flags.SetOutput(os.Stderr)
+	for _, arg := range args {
+		if arg == "-h" || arg == "-help" || arg == "--help" {
+			flags.SetOutput(os.Stdout)
+			flags.Usage()
+			return exitOK, false
 		}
-		if strings.HasSuffix(entry.Name(), "jack") {
-			name := dirname + "/" + entry.Name()
-			filenames = append(filenames, name)
+	}
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK, false
 		}
+		return exitUsage, false
 	}
+	return exitOK, true
+}
 
-	return filenames
+func usageError(flags *flag.FlagSet, msg string) int {
+	fmt.Fprintf(os.Stderr, "jack %s: %s\n\n", flags.Name(), msg)
+	flags.Usage()
+	return exitUsage
 }
 
-func writeToFile(filename string, content string) {
-	outputFilename := strings.Replace(filename, ".jack", ".vm", 1)
-	fmt.Printf("output:\t%s\n", outputFilename)
+// errNotFound is the error of a file or directory given that does not exist, a usage error.
+var errNotFound = errors.New("no such file or directory")
 
-	err := os.WriteFile(outputFilename, []byte(content), 0666)
-	logger.Error(err)
+// statPath describes a file or directory given, wrapping errNotFound when it does not exist.
+func statPath(name string) (fs.FileInfo, error) {
+	info, err := os.Stat(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", name, errNotFound)
+	}
+	return info, err
+}
+
+// sourcesError reports an error collecting the sources, returning the exit code, a file or
+// directory not found being a usage error.
+func sourcesError(flags *flag.FlagSet, err error) int {
+	if errors.Is(err, errNotFound) {
+		return usageError(flags, err.Error())
+	}
+	logger.Fail("", err)
+	return exitFailed
+}
+
+// compileFlags are the flags of the commands compiling jack files.
+type compileFlags struct {
+	extensions string
+	include    string
+	exclude    string
+	defs       string
+	withOS     bool
+}
+
+func addCompileFlags(flags *flag.FlagSet) *compileFlags {
+	cf := &compileFlags{}
+	flags.StringVar(&cf.extensions, "x", "", "comma separated language extensions to enable: "+engine.ExtensionNames())
+	flags.BoolVar(&opts.Strict, "strict", false, "warn about expressions depending on operator evaluation order")
+	flags.BoolVar(&opts.InternStrings, "intern", false, "create each distinct string constant once per class, they must not be mutated")
+	flags.StringVar(&cf.defs, "defs", "", "comma separated .jackdef files, or directories of them, describing precompiled classes calls are checked against, os for the Jack OS")
+	flags.StringVar(&diagnosticsFormat, "diagnostics-format", textFormat, "format of the errors and warnings: text, gcc (one per line), json or sarif, the last two written to the standard output")
+	cf.addDiscoveryFlags(flags)
+	return cf
+}
+
+func (cf *compileFlags) addDiscoveryFlags(flags *flag.FlagSet) {
+	flags.StringVar(&cf.include, "include", "", "comma separated globs of the files to compile within directories, ** matches any directories")
+	flags.StringVar(&cf.exclude, "exclude", "", "comma separated globs of the files and directories to skip within directories")
+}
+
+func (cf *compileFlags) addOSFlag(flags *flag.FlagSet) {
+	flags.BoolVar(&cf.withOS, "with-os", false, "compile the bundled Jack OS along, but for the OS classes among the sources")
 }
+
+// apply sets the compiler options from the flags.
+func (cf *compileFlags) apply() error {
+	switch diagnosticsFormat {
+	case textFormat, gccFormat, jsonFormat, sarifFormat:
+	default:
+		return fmt.Errorf("unknown diagnostics format %q, available: text, gcc, json, sarif", diagnosticsFormat)
+	}
+	var err error
+	if libraryDefs, err = loadDefs(cf.defs); err != nil {
+		return fmt.Errorf("error reading class descriptors: %w", err)
+	}
+	opts.Extensions, err = engine.ParseExtensions(cf.extensions)
+	return err
+}
+
+// discover collects the sources, and reads the declarations of the classes among them.
+func (cf *compileFlags) discover(paths []string) ([]source, error) {
+	sources, err := cf.sources(paths)
+	if err != nil {
+		return nil, err
+	}
+	return sources, declare(sources)
+}
+
+// sources collects the sources, without reading them.
+func (cf *compileFlags) sources(paths []string) ([]source, error) {
+	return discoverSources(paths, splitList(cf.include), splitList(cf.exclude), cf.withOS)
+}
+
+var (
+	opts      engine.Options
+	outputDir string
+	toStdout  bool
+	// compile without writing the vm files
+	checkOnly bool
+	// one line per diagnostic, instead of the input and output of every file
+	compact bool
+	// write the descriptor of every class compiled next to its vm file
+	writeDefs bool
+)
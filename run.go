@@ -0,0 +1,156 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hlmerscher/jack-compiler-go/emulator"
+	"github.com/hlmerscher/jack-compiler-go/logger"
+)
+
+func runCommand(args []string) int {
+	var maxSteps int
+	var inputFilename string
+	flags := newFlagSet("run", "<files or directories>")
+	cf := addCompileFlags(flags)
+	cf.addOSFlag(flags)
+	flags.IntVar(&maxSteps, "max-steps", 0, "stop with an error after that many vm instructions, 0 runs until the program halts")
+	flags.StringVar(&inputFilename, "input", "", "a file with the keys typed ahead for the Keyboard functions of the built-in OS")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	if flags.NArg() == 0 {
+		return usageError(flags, "no files or directories given")
+	}
+	if err := cf.apply(); err != nil {
+		return usageError(flags, err.Error())
+	}
+
+	machine := emulator.New()
+	if inputFilename != "" {
+		input, err := os.ReadFile(inputFilename)
+		if err != nil {
+			logger.Fail("", err)
+			return exitFailed
+		}
+		machine.Type(string(input))
+	}
+	classes, code := compileProgram(flags, cf)
+	flushDiagnostics()
+	if code != exitOK {
+		return code
+	}
+	if !loadProgram(machine, classes) {
+		return exitFailed
+	}
+	if err := machine.Boot(); err != nil {
+		logger.Fail("", err)
+		return exitFailed
+	}
+	err := machine.Run(maxSteps)
+	// what the program printed with the built-in OS
+	if text := machine.Text(); text != "" {
+		fmt.Println(text)
+	}
+	if err != nil {
+		logger.Fail("", err)
+		return exitFailed
+	}
+	// Sys.halt, or a loop jumping to itself, ends the program without returning
+	if len(machine.CallStack()) > 0 {
+		fmt.Printf("halted after %d steps\n", machine.Steps)
+	} else {
+		fmt.Printf("returned %d after %d steps\n", machine.Result(), machine.Steps)
+	}
+
+	return exitOK
+}
+
+// vmClass is the vm code of a class of the program, along with the compilation of its jack
+// source, if it has one.
+type vmClass struct {
+	name string
+	code string
+	comp *compilation
+}
+
+// compileProgram compiles the jack files given, or within the directories given, returning
+// their vm code along with the vm files of the classes without jack sources, and the OS classes
+// without either when the OS is compiled along, or the exit code when it fails.
+func compileProgram(flags *flag.FlagSet, cf *compileFlags) ([]vmClass, int) {
+	checkOnly = true
+	var jackPaths, vmFilenames []string
+	for _, name := range flags.Args() {
+		info, err := statPath(name)
+		if err != nil {
+			return nil, sourcesError(flags, err)
+		}
+		switch {
+		case info.IsDir():
+			jackPaths = append(jackPaths, name)
+			filenames, err := dirFilenames(name, ".vm", splitList(cf.include), splitList(cf.exclude))
+			if err != nil {
+				logger.Fail("error reading directory\n", err)
+				return nil, exitFailed
+			}
+			vmFilenames = append(vmFilenames, filenames...)
+		case filepath.Ext(name) == ".vm":
+			vmFilenames = append(vmFilenames, name)
+		default:
+			jackPaths = append(jackPaths, name)
+		}
+	}
+
+	var compilations []*compilation
+	if len(jackPaths) > 0 || cf.withOS {
+		sources, err := cf.discover(jackPaths)
+		if err != nil {
+			return nil, sourcesError(flags, err)
+		}
+		compilations = analyzeFiles(sources, 1)
+		if failures(compilations) > 0 {
+			return nil, exitFailed
+		}
+	}
+	vmClasses := make(map[string]bool)
+	for _, filename := range vmFilenames {
+		vmClasses[strings.TrimSuffix(filepath.Base(filename), ".vm")] = true
+	}
+	var classes []vmClass
+	compiled := make(map[string]bool)
+	for _, comp := range compilations {
+		// the user's vm files replace the OS classes too
+		if comp.embedded && vmClasses[comp.result.ClassName] {
+			continue
+		}
+		compiled[comp.result.ClassName] = true
+		classes = append(classes, vmClass{comp.result.ClassName, comp.code, comp})
+	}
+	for _, filename := range vmFilenames {
+		className := strings.TrimSuffix(filepath.Base(filename), ".vm")
+		if compiled[className] {
+			continue
+		}
+		code, err := os.ReadFile(filename)
+		if err != nil {
+			logger.Fail("", err)
+			return nil, exitFailed
+		}
+		classes = append(classes, vmClass{className, string(code), nil})
+	}
+	return classes, exitOK
+}
+
+// loadProgram loads the vm code of the classes into the machine.
+func loadProgram(machine *emulator.Machine, classes []vmClass) bool {
+	for _, class := range classes {
+		if err := machine.Load(class.name, class.code); err != nil {
+			logger.Fail("", err)
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hlmerscher/jack-compiler-go/emulator"
+	"github.com/hlmerscher/jack-compiler-go/logger"
+)
+
+func scriptCommand(args []string) int {
+	flags := newFlagSet("script", "<.tst files or directories>")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	if flags.NArg() == 0 {
+		return usageError(flags, "no files or directories given")
+	}
+
+	var filenames []string
+	for _, name := range flags.Args() {
+		info, err := statPath(name)
+		if err != nil {
+			return sourcesError(flags, err)
+		}
+		if !info.IsDir() {
+			filenames = append(filenames, name)
+			continue
+		}
+		found, err := dirFilenames(name, ".tst", nil, nil)
+		if err != nil {
+			logger.Fail("error reading directory\n", err)
+			return exitFailed
+		}
+		filenames = append(filenames, found...)
+	}
+
+	var failed int
+	for _, filename := range filenames {
+		script, err := emulator.ReadScript(filename)
+		if err == nil {
+			err = script.Run()
+		}
+		var comparisonErr *emulator.ComparisonError
+		switch {
+		case errors.As(err, &comparisonErr):
+			failed++
+			fmt.Printf("FAIL %s: comparison failure at line %d\n    expected: %s\n    actual:   %s\n",
+				filename, comparisonErr.Line, comparisonErr.Expected, comparisonErr.Actual)
+		case err != nil:
+			failed++
+			fmt.Printf("FAIL %s\n", filename)
+			logger.Fail("", err)
+		default:
+			fmt.Printf("ok   %s\n", filename)
+		}
+	}
+	if failed > 0 {
+		return exitFailed
+	}
+	return exitOK
+}
@@ -0,0 +1,174 @@
+package symbols
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// Kind tells where a symbol lives, its values name the vm segments in vm.VarTypes.
+type Kind string
+
+const (
+	Static = Kind("static")
+	Field  = Kind("field")
+	Arg    = Kind("arg")
+	Var    = Kind("var")
+	// class constants, inlined where they are used, they take no index
+	Const = Kind("const")
+	// the this object, of the class type
+	Class = Kind("class")
+)
+
+// Symbol is a declared name.
+type Symbol struct {
+	Name  string
+	Type  string
+	Kind  Kind
+	Index int
+	// value of constants
+	Value int
+	// where the symbol is declared, counting from 1, zero when not declared in the source
+	Line   int
+	Column int
+}
+
+func (s *Symbol) String() string {
+	return fmt.Sprintf("{index:%d type:%s kind:%s}", s.Index, s.Type, s.Kind)
+}
+
+// Scope holds the symbols of a class or of a subroutine, indexed per kind in declaration order.
+type Scope struct {
+	// name of the class, or of the subroutine as Class.name
+	Name string
+	// kind of the subroutine, or class
+	Kind    string
+	symbols map[string]*Symbol
+	order   []*Symbol
+	counts  map[Kind]int
+}
+
+func newScope(name, kind string) *Scope {
+	return &Scope{
+		Name:    name,
+		Kind:    kind,
+		symbols: make(map[string]*Symbol),
+		counts:  make(map[Kind]int),
+	}
+}
+
+// Lookup finds a symbol declared in the scope itself.
+func (s *Scope) Lookup(name string) (*Symbol, bool) {
+	symbol, ok := s.symbols[name]
+	return symbol, ok
+}
+
+// Symbols returns the symbols of the scope in declaration order.
+func (s *Scope) Symbols() []*Symbol {
+	return s.order
+}
+
+// Table is the symbol table of a class being compiled, with the scope of the class and the
+// scope of the current subroutine, which shadows the class scope.
+type Table struct {
+	class      *Scope
+	subroutine *Scope
+	// scopes of the subroutines compiled so far
+	subroutines []*Scope
+}
+
+func New() *Table {
+	return &Table{class: newScope("", "class"), subroutine: newScope("", "")}
+}
+
+// StartClass starts an empty class scope, where this refers to an object of the class.
+func (t *Table) StartClass(name string) {
+	t.class = newScope(name, "class")
+	t.subroutine = newScope("", "")
+	t.subroutines = nil
+	t.class.add(&Symbol{Name: "this", Type: name, Kind: Class})
+}
+
+// StartSubroutine starts an empty subroutine scope, methods take the object as argument 0.
+func (t *Table) StartSubroutine(name, kind string) {
+	t.subroutine = newScope(t.class.Name+"."+name, kind)
+	if kind == "method" {
+		t.subroutine.counts[Arg] = 1
+	}
+	t.subroutines = append(t.subroutines, t.subroutine)
+}
+
+// Define declares a symbol in the scope of its kind, statics, fields and constants in the
+// class scope, arguments and local variables in the subroutine scope. The symbol takes the
+// next index of its kind, unless it is a constant. A name is declared once per scope.
+func (t *Table) Define(symbol *Symbol) (*Symbol, error) {
+	scope := t.subroutine
+	if symbol.Kind == Static || symbol.Kind == Field || symbol.Kind == Const || symbol.Kind == Class {
+		scope = t.class
+	}
+	if previous, ok := scope.symbols[symbol.Name]; ok {
+		return previous, fmt.Errorf("%q already declared", symbol.Name)
+	}
+	if symbol.Kind != Const && symbol.Kind != Class {
+		symbol.Index = scope.counts[symbol.Kind]
+		scope.counts[symbol.Kind]++
+	}
+	scope.add(symbol)
+	return symbol, nil
+}
+
+func (s *Scope) add(symbol *Symbol) {
+	s.symbols[symbol.Name] = symbol
+	s.order = append(s.order, symbol)
+}
+
+// Lookup finds a symbol in the subroutine scope, then in the class scope.
+func (t *Table) Lookup(name string) (*Symbol, bool) {
+	if symbol, ok := t.subroutine.symbols[name]; ok {
+		return symbol, true
+	}
+	symbol, ok := t.class.symbols[name]
+	return symbol, ok
+}
+
+// VarCount is the number of symbols of the kind in the current scopes, for arguments it
+// includes the object of methods.
+func (t *Table) VarCount(kind Kind) int {
+	return t.class.counts[kind] + t.subroutine.counts[kind]
+}
+
+func (t *Table) ClassName() string {
+	return t.class.Name
+}
+
+func (t *Table) Class() *Scope {
+	return t.class
+}
+
+func (t *Table) Subroutine() *Scope {
+	return t.subroutine
+}
+
+// Subroutines returns the scopes of the subroutines compiled so far, in order.
+func (t *Table) Subroutines() []*Scope {
+	return t.subroutines
+}
+
+// Dump writes the class scope and the scopes of the subroutines, one symbol per line.
+func (t *Table) Dump(out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for _, scope := range append([]*Scope{t.class}, t.subroutines...) {
+		fmt.Fprintf(w, "%s %s\n", scope.Kind, scope.Name)
+		for _, symbol := range scope.order {
+			if symbol.Kind == Class {
+				continue
+			}
+			place := fmt.Sprint(symbol.Index)
+			if symbol.Kind == Const {
+				place = fmt.Sprintf("= %d", symbol.Value)
+			}
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%s\tline %d\n", symbol.Kind, symbol.Type, symbol.Name, place, symbol.Line)
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hlmerscher/jack-compiler-go/emulator"
+	"github.com/hlmerscher/jack-compiler-go/engine"
+)
+
+// assertDef describes the Assert class provided to the tests, so their calls are checked.
+var assertDef = engine.ClassDef{
+	Class:   "Assert",
+	Statics: []engine.Variable{},
+	Subroutines: []engine.Signature{
+		{Kind: "function", Name: "equals", ReturnType: "void", Params: []string{"int", "int"}},
+		{Kind: "function", Name: "isTrue", ReturnType: "void", Params: []string{"boolean"}},
+		{Kind: "function", Name: "fail", ReturnType: "void", Params: []string{"String"}},
+	},
+}
+
+// OS functions initializing the OS classes, called before each test when compiled along
+var osInits = []string{"Memory.init", "Math.init", "Screen.init", "Output.init", "Keyboard.init"}
+
+// testCase is a test function of a test class.
+type testCase struct {
+	class    vmClass
+	function string
+}
+
+type testResult struct {
+	testCase
+	steps    int
+	duration time.Duration
+	// what failed, and where in the jack sources, empty when the test passed
+	failure  string
+	location string
+	// failed with a runtime error, rather than an assertion
+	crashed bool
+}
+
+func testCommand(args []string) int {
+	var run, junitFilename string
+	var maxSteps int
+	var verbose bool
+	flags := newFlagSet("test", "<files or directories>")
+	cf := addCompileFlags(flags)
+	cf.addOSFlag(flags)
+	flags.StringVar(&run, "run", "", "only run the tests whose Class.testName matches the regular expression")
+	flags.IntVar(&maxSteps, "max-steps", 10000000, "fail the tests still running after that many vm instructions")
+	flags.BoolVar(&verbose, "v", false, "list every test run, not only the failing ones")
+	flags.StringVar(&junitFilename, "junit", "", "also write a JUnit XML report to the file")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	if flags.NArg() == 0 {
+		return usageError(flags, "no files or directories given")
+	}
+	filter, err := regexp.Compile(run)
+	if err != nil {
+		return usageError(flags, err.Error())
+	}
+	if err := cf.apply(); err != nil {
+		return usageError(flags, err.Error())
+	}
+	opts.LineComments = true
+	libraryDefs[assertDef.Class] = assertDef
+
+	classes, code := compileProgram(flags, cf)
+	flushDiagnostics()
+	if code != exitOK {
+		return code
+	}
+	if !loadProgram(emulator.New(), classes) {
+		return exitFailed
+	}
+
+	tests := findTests(classes, filter)
+	if len(tests) == 0 {
+		fmt.Println("no tests to run")
+		return exitOK
+	}
+	var results []testResult
+	// counted apart like in the JUnit report, assertions failed and runtime errors
+	var failed, crashed int
+	for _, test := range tests {
+		result := runTest(classes, test, maxSteps)
+		results = append(results, result)
+		if result.failure != "" {
+			if result.crashed {
+				crashed++
+			} else {
+				failed++
+			}
+			fmt.Printf("--- FAIL: %s (%d steps)\n", result.function, result.steps)
+			fmt.Printf("    %s\n", strings.ReplaceAll(result.message(), "\n", "\n    "))
+		} else if verbose {
+			fmt.Printf("--- PASS: %s (%d steps)\n", result.function, result.steps)
+		}
+	}
+
+	if junitFilename != "" {
+		if err := writeJUnit(junitFilename, results); err != nil {
+			fmt.Fprintf(os.Stderr, "jack test: error writing the JUnit report: %v\n", err)
+			return exitFailed
+		}
+	}
+	if failed+crashed > 0 {
+		errorsWord := "errors"
+		if crashed == 1 {
+			errorsWord = "error"
+		}
+		fmt.Printf("FAIL: %d failed, %d %s of %d tests\n", failed, crashed, errorsWord, len(results))
+		return exitFailed
+	}
+	fmt.Printf("ok: %d tests passed\n", len(results))
+	return exitOK
+}
+
+// findTests returns the test functions of the classes compiled from *Test.jack files, the
+// void functions without parameters whose names start with test, in declaration order.
+func findTests(classes []vmClass, filter *regexp.Regexp) []testCase {
+	var tests []testCase
+	for _, class := range classes {
+		if class.comp == nil || class.comp.embedded || !strings.HasSuffix(class.comp.filename, "Test.jack") {
+			continue
+		}
+		for _, signature := range class.comp.result.Subroutines {
+			function := class.name + "." + signature.Name
+			if signature.Kind != "function" || !strings.HasPrefix(signature.Name, "test") ||
+				signature.ReturnType != "void" || len(signature.Params) > 0 || !filter.MatchString(function) {
+				continue
+			}
+			tests = append(tests, testCase{class, function})
+		}
+	}
+	return tests
+}
+
+// errAssertion stops a test at its first failing assertion.
+var errAssertion = errors.New("assertion failed")
+
+// runTest runs the test function in a machine of its own, so every test starts with a fresh heap.
+func runTest(classes []vmClass, test testCase, maxSteps int) (result testResult) {
+	result.testCase = test
+	start := time.Now()
+	defer func() {
+		result.duration = time.Since(start)
+	}()
+
+	machine := emulator.New()
+	fail := func(m *emulator.Machine, format string, args ...any) (int16, error) {
+		result.failure = fmt.Sprintf(format, args...)
+		result.location = jackLocation(classes, m.Trace())
+		return 0, errAssertion
+	}
+	machine.Define("Assert.equals", 2, func(m *emulator.Machine, args []int16) (int16, error) {
+		if args[0] != args[1] {
+			return fail(m, "expected %d, got %d", args[1], args[0])
+		}
+		return 0, nil
+	})
+	machine.Define("Assert.isTrue", 1, func(m *emulator.Machine, args []int16) (int16, error) {
+		if args[0] == 0 {
+			return fail(m, "expected true, got false")
+		}
+		return 0, nil
+	})
+	machine.Define("Assert.fail", 1, func(m *emulator.Machine, args []int16) (int16, error) {
+		msg, err := m.ReadString(args[0])
+		if err != nil {
+			return 0, err
+		}
+		return fail(m, "%s", msg)
+	})
+	// Sys.init of the OS compiled along calls it, while the tests may have no Main class
+	machine.Define("Main.main", 0, func(m *emulator.Machine, args []int16) (int16, error) {
+		return 0, nil
+	})
+	if !loadProgram(machine, classes) {
+		result.failure, result.crashed = "the program cannot be loaded", true
+		return result
+	}
+
+	functions := []string{test.function}
+	for i := len(osInits) - 1; i >= 0; i-- {
+		if machine.HasFunction(osInits[i]) {
+			functions = append([]string{osInits[i]}, functions...)
+		}
+	}
+	for _, function := range functions {
+		err := machine.Call(function)
+		if err == nil {
+			err = machine.Run(maxSteps)
+		}
+		result.steps = machine.Steps
+		if result.failure != "" {
+			return result
+		}
+		if err != nil {
+			result.crashed = true
+			result.failure = err.Error()
+			var runtimeErr *emulator.RuntimeError
+			if errors.As(err, &runtimeErr) {
+				result.failure = runtimeErr.Msg
+			}
+			result.location = jackLocation(classes, machine.Trace())
+			return result
+		}
+		// Sys.halt was reached, like after Sys.error of the OS compiled along
+		if stack := machine.CallStack(); len(stack) > 0 {
+			result.crashed = true
+			result.failure = "halted before returning, in " + strings.Join(stack, " -> ")
+			result.location = jackLocation(classes, machine.Trace())
+			return result
+		}
+	}
+	return result
+}
+
+func (r testResult) message() string {
+	if r.location == "" {
+		return r.failure
+	}
+	return r.location + ": " + r.failure
+}
+
+// jackLocation returns the place in the jack sources of the innermost function with one, as
+// file:line, from the line comments of the vm code. Functions of the program come first,
+// the OS compiled along is where the program called into it.
+func jackLocation(classes []vmClass, trace []emulator.Location) string {
+	for _, embedded := range []bool{false, true} {
+		for i := len(trace) - 1; i >= 0; i-- {
+			for _, class := range classes {
+				if class.name != trace[i].File || class.comp == nil || class.comp.embedded != embedded {
+					continue
+				}
+				if line := sourceLineOf(class.code, trace[i].Line); line > 0 {
+					return fmt.Sprintf("%s:%d", class.comp.filename, line)
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// sourceLineOf returns the jack line of the statement the vm line belongs to, from the last
+// // line N comment before it.
+func sourceLineOf(code string, vmLine int) int {
+	lines := strings.Split(code, "\n")
+	if vmLine > len(lines) {
+		return 0
+	}
+	for i := vmLine - 1; i >= 0; i-- {
+		if strings.HasPrefix(lines[i], "// line ") {
+			line, _ := strconv.Atoi(strings.TrimPrefix(lines[i], "// line "))
+			return line
+		}
+	}
+	return 0
+}
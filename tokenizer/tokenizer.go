@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"io"
 	"regexp"
-	"strconv"
 	"strings"
 
 	"golang.org/x/exp/slices"
@@ -30,10 +29,21 @@ type Tokenizer struct {
 	tokenizedLine string
 	LineNr        int
 	Current       Token
+	// the token before the current one
+	Previous Token
+	// every line read so far, as in the source but for tabs turned into spaces
+	lines []string
+	// leading spaces trimmed off the current line
+	indent int
+	// called with every token consumed by the parser, when set
+	Consumed func(Token)
+	eof      bool
+	// enables 'a' char literals and backslash escapes in string constants
+	CharLiterals bool
 }
 
 func (tk *Tokenizer) HasMoreTokens() bool {
-	return false
+	return !tk.eof
 }
 
 func (tk *Tokenizer) Advance() (Token, error) {
@@ -47,6 +57,9 @@ func (tk *Tokenizer) Advance() (Token, error) {
 		return tk.Advance()
 	}
 	if errors.Is(err, io.EOF) {
+		tk.eof = true
+		tk.Previous = tk.Current
+		tk.Current = EmptyToken
 		return tk.Current, nil
 	}
 	if err != nil {
@@ -63,8 +76,13 @@ func (tk *Tokenizer) nextToken() Token {
 		return EmptyToken
 	}
 
+	if tk.CharLiterals && (line[0] == '"' || line[0] == '\'') {
+		return tk.nextQuotedToken()
+	}
+
 	var rawToken strings.Builder
 
+	column := tk.column()
 	var currentIndex int
 	for i, char := range tk.tokenizedLine {
 		currentIndex = i
@@ -92,33 +110,93 @@ func (tk *Tokenizer) nextToken() Token {
 		}
 		rawToken.WriteRune(char)
 	}
+	// a token ending the line is not followed by a space or symbol ending it
+	if rawToken.Len() == len(line) {
+		currentIndex = len(line)
+	}
 	tk.tokenizedLine = strings.Trim(line[currentIndex:], " ")
 
+	tk.Previous = tk.Current
+	tk.Current = Token{
+		Raw:    rawToken.String(),
+		Type:   parseTokenType(rawToken.String()),
+		Line:   tk.LineNr,
+		Column: column,
+	}
+
+	return tk.Current
+}
+
+// nextQuotedToken reads a string constant or char literal, skipping over escaped quotes.
+func (tk *Tokenizer) nextQuotedToken() Token {
+	quote := tk.tokenizedLine[0]
+	end := len(tk.tokenizedLine)
+	for i := 1; i < len(tk.tokenizedLine); i++ {
+		if tk.tokenizedLine[i] == '\\' {
+			i++
+			continue
+		}
+		if tk.tokenizedLine[i] == quote {
+			end = i + 1
+			break
+		}
+	}
+
+	raw := tk.tokenizedLine[:end]
+	column := tk.column()
+	tk.tokenizedLine = strings.Trim(tk.tokenizedLine[end:], " ")
+
+	tokenType := STRING_CONST
+	if quote == '\'' {
+		tokenType = CHAR_CONST
+	}
+	tk.Previous = tk.Current
 	tk.Current = Token{
-		Raw:  rawToken.String(),
-		Type: parseTokenType(rawToken.String()),
+		Raw:    raw,
+		Type:   tokenType,
+		Line:   tk.LineNr,
+		Column: column,
 	}
 
 	return tk.Current
 }
 
+// column is where the rest of the current line starts in the source line, counting from 1.
+func (tk *Tokenizer) column() int {
+	rest := strings.TrimLeft(tk.tokenizedLine, " ")
+	return tk.indent + len(tk.CurrentLine) - len(rest) + 1
+}
+
+// RestOfLine returns the source after the current token, up to the end of its line.
+func (tk *Tokenizer) RestOfLine() string {
+	return strings.TrimLeft(tk.tokenizedLine, " ")
+}
+
+// SourceLine returns the line of the source with the number, as read so far.
+func (tk *Tokenizer) SourceLine(lineNr int) string {
+	if lineNr < 1 || lineNr > len(tk.lines) {
+		return ""
+	}
+	return tk.lines[lineNr-1]
+}
+
 func (tk *Tokenizer) ReadLine() (string, error) {
-	line, err := nextLine(tk.input)
+	line, err := tk.nextLine()
 	if err != nil {
 		return "", err
 	}
-	tk.LineNr++
+	tk.indent = len(line) - len(strings.TrimLeft(line, " "))
+	line = strings.TrimLeft(line, " ")
 
 	if isSingleLineComment(line) {
 		return "", Ignored
 	}
 	if isMultiLineComment(line) {
 		for {
-			line, err = nextLine(tk.input)
+			line, err = tk.nextLine()
 			if err != nil {
 				return "", err
 			}
-			tk.LineNr++
 
 			if isEndOfMultiLineComment(line) {
 				return "", Ignored
@@ -137,18 +215,50 @@ func (tk *Tokenizer) ReadLine() (string, error) {
 	return line, nil
 }
 
-func nextLine(input *bufio.Reader) (string, error) {
-	line, err := input.ReadString('\n')
+// nextLine reads a line, keeping it for diagnostics, and trims its trailing spaces.
+func (tk *Tokenizer) nextLine() (string, error) {
+	line, err := tk.input.ReadString('\n')
+	// the last line may have no line break
+	if errors.Is(err, io.EOF) && line != "" {
+		err = nil
+	}
 	if err != nil {
 		return "", err
 	}
 	line = strings.ReplaceAll(line, "\r", "")
 	line = strings.ReplaceAll(line, "\n", "")
-	line = strings.ReplaceAll(line, "\t", "")
-	line = strings.Trim(line, " ")
+	line = replaceTabs(line, tk.CharLiterals)
+	tk.lines = append(tk.lines, line)
+	tk.LineNr++
+	line = strings.TrimRight(line, " ")
 	return line, nil
 }
 
+// replaceTabs turns tabs into spaces, except inside string constants, where they are kept
+// so the compiler can report them as characters out of the hack character set. Backslash
+// escapes and char literals only exist with the chars extension.
+func replaceTabs(line string, charLiterals bool) string {
+	var out strings.Builder
+	var quote rune
+	escaped := false
+	for _, char := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case quote != 0 && char == '\\' && charLiterals:
+			escaped = true
+		case quote != 0 && char == quote:
+			quote = 0
+		case quote == 0 && (char == '"' || char == '\'' && charLiterals):
+			quote = char
+		case char == '\t' && quote == 0:
+			char = ' '
+		}
+		out.WriteRune(char)
+	}
+	return out.String()
+}
+
 func isSingleLineComment(line string) bool {
 	return strings.HasPrefix(line, "//") || strings.HasPrefix(line, "/*") && strings.HasSuffix(line, "*/")
 }
@@ -174,28 +284,33 @@ const (
 	IDENTIFIER   = TokenType("identifier")
 	INT_CONST    = TokenType("integerConstant")
 	STRING_CONST = TokenType("stringConstant")
+	CHAR_CONST   = TokenType("charConstant")
 	UNKNOWN      = TokenType("UNKNOWN")
 )
 
-type Var struct {
-	Index int
-	Type  string
-	Kind  string
-}
-
-func (v *Var) String() string {
-	return fmt.Sprintf("{index:%d type:%s kind:%s}", v.Index, v.Type, v.Kind)
-}
-
 type Token struct {
 	Raw  string
 	Type TokenType
+	// where the token starts in the source, counting from 1
+	Line   int
+	Column int
 }
 
 func (t *Token) String() string {
 	return fmt.Sprintf("%s:%s", t.Type, t.Raw)
 }
 
+var xmlEscaper = strings.NewReplacer("<", "&lt;", ">", "&gt;", "&", "&amp;", "\"", "&quot;")
+
+// XML formats the token as in the nand2tetris tokenizer output, like <keyword> class </keyword>.
+func (t Token) XML() string {
+	text := t.Raw
+	if t.Type == STRING_CONST || t.Type == CHAR_CONST {
+		text = text[1 : len(text)-1]
+	}
+	return fmt.Sprintf("<%s> %s </%s>", t.Type, xmlEscaper.Replace(text), t.Type)
+}
+
 func parseTokenType(value string) TokenType {
 	switch {
 	case isKeyword(value):
@@ -244,7 +359,7 @@ var symbols = []string{
 	"{", "}",
 	"(", ")",
 	"[", "]",
-	".", ",", ";",
+	".", ",", ";", ":",
 	"+", "-", "*", "/",
 	"&", "|",
 	"<", ">",
@@ -256,8 +371,7 @@ func isSymbol(value string) bool {
 }
 
 func isInteger(value string) bool {
-	_, err := strconv.Atoi(value)
-	return err == nil
+	return regexp.MustCompile(`^[0-9]+$`).MatchString(value)
 }
 
 func isString(value string) bool {
@@ -1,6 +1,7 @@
 package vm
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 
@@ -53,13 +54,22 @@ var VarTypes = map[string]string{
 	"constant": "constant",
 }
 
-var (
-	ifCounter    int
-	whileCounter int
-)
-
 type Writer struct {
 	out *strings.Builder
+	// label counters, kept per writer so files can be compiled concurrently
+	ifCounter           int
+	whileCounter        int
+	shortCircuitCounter int
+	switchCounter       int
+	initCounter         int
+	// labels of the enclosing loops, the innermost last
+	loops []loopLabels
+}
+
+// loopLabels of a switch have no continue label, continue refers to the enclosing loop
+type loopLabels struct {
+	continueLabel string
+	breakLabel    string
 }
 
 func (w *Writer) Output() string {
@@ -145,11 +155,11 @@ func (w *Writer) WriteCall(subroutineType, subroutineName string, nStackVars int
 }
 
 func (w *Writer) WriteWhile(expressionFn func() error, statementsFn func() error) error {
-	t := fmt.Sprintf("WHILE_EXP_%d", whileCounter)
-	f := fmt.Sprintf("WHILE_END_%d", whileCounter)
+	t := fmt.Sprintf("WHILE_EXP_%d", w.whileCounter)
+	f := fmt.Sprintf("WHILE_END_%d", w.whileCounter)
 	labelT := fmt.Sprintf("label %s\n", t)
 	labelF := fmt.Sprintf("label %s\n", f)
-	whileCounter++
+	w.whileCounter++
 
 	w.out.WriteString(labelT)
 	if err := expressionFn(); err != nil { // compiled expression
@@ -157,7 +167,7 @@ func (w *Writer) WriteWhile(expressionFn func() error, statementsFn func() error
 	}
 	w.out.WriteString("not\n")
 	w.out.WriteString(fmt.Sprintf("if-goto %s\n", f))
-	if err := statementsFn(); err != nil { // compiled statements
+	if err := w.loop(t, f, statementsFn); err != nil { // compiled statements
 		return err
 	}
 	w.out.WriteString(fmt.Sprintf("goto %s\n", t))
@@ -166,12 +176,140 @@ func (w *Writer) WriteWhile(expressionFn func() error, statementsFn func() error
 	return nil
 }
 
+// WriteFor lowers a for loop onto the while labels, with the step written after the statements,
+// even though it is compiled before them.
+func (w *Writer) WriteFor(expressionFn func() error, stepFn func() error, statementsFn func() error) error {
+	t := fmt.Sprintf("WHILE_EXP_%d", w.whileCounter)
+	f := fmt.Sprintf("WHILE_END_%d", w.whileCounter)
+	s := fmt.Sprintf("WHILE_STEP_%d", w.whileCounter)
+	w.whileCounter++
+
+	w.out.WriteString(fmt.Sprintf("label %s\n", t))
+	if err := expressionFn(); err != nil { // compiled expression
+		return err
+	}
+	w.out.WriteString("not\n")
+	w.out.WriteString(fmt.Sprintf("if-goto %s\n", f))
+	step, err := w.Capture(stepFn) // compiled step
+	if err != nil {
+		return err
+	}
+	if err := w.loop(s, f, statementsFn); err != nil { // compiled statements
+		return err
+	}
+	w.out.WriteString(fmt.Sprintf("label %s\n", s))
+	w.out.WriteString(step)
+	w.out.WriteString(fmt.Sprintf("goto %s\n", t))
+	w.out.WriteString(fmt.Sprintf("label %s\n", f))
+
+	return nil
+}
+
+func (w *Writer) WriteBreak() error {
+	if len(w.loops) == 0 {
+		return errors.New("break outside of a loop")
+	}
+	_, err := w.out.WriteString(fmt.Sprintf("goto %s\n", w.loops[len(w.loops)-1].breakLabel))
+	return err
+}
+
+func (w *Writer) WriteContinue() error {
+	for i := len(w.loops) - 1; i >= 0; i-- {
+		if w.loops[i].continueLabel == "" {
+			continue
+		}
+		_, err := w.out.WriteString(fmt.Sprintf("goto %s\n", w.loops[i].continueLabel))
+		return err
+	}
+	return errors.New("continue outside of a loop")
+}
+
+// WriteSwitch writes the end label of a switch, which break jumps to from within its cases.
+func (w *Writer) WriteSwitch(casesFn func() error) error {
+	end := fmt.Sprintf("SWITCH_END_%d", w.switchCounter)
+	w.switchCounter++
+
+	if err := w.loop("", end, casesFn); err != nil { // compiled cases
+		return err
+	}
+	w.out.WriteString(fmt.Sprintf("label %s\n", end))
+
+	return nil
+}
+
+func (w *Writer) loop(continueLabel, breakLabel string, statementsFn func() error) error {
+	w.loops = append(w.loops, loopLabels{continueLabel: continueLabel, breakLabel: breakLabel})
+	defer func() { w.loops = w.loops[:len(w.loops)-1] }()
+
+	return statementsFn()
+}
+
+// Capture compiles fn into a separate buffer, returning its code instead of writing it.
+func (w *Writer) Capture(fn func() error) (string, error) {
+	out := w.out
+	w.out = new(strings.Builder)
+	defer func() { w.out = out }()
+
+	err := fn()
+	return w.out.String(), err
+}
+
+// WriteInitGuard calls the init function, unless the static flag tells it already ran.
+func (w *Writer) WriteInitGuard(class, function string, flagIndex int) error {
+	done := fmt.Sprintf("INIT_DONE_%d", w.initCounter)
+	w.initCounter++
+
+	w.WritePush("static", flagIndex)
+	w.out.WriteString(fmt.Sprintf("if-goto %s\n", done))
+	w.WriteCall(class, function, 0)
+	w.WritePop("temp", 0)
+	w.out.WriteString(fmt.Sprintf("label %s\n", done))
+
+	return nil
+}
+
+// WriteComment writes a comment line, ignored by vm translators and emulators.
+func (w *Writer) WriteComment(text string) error {
+	_, err := w.out.WriteString("// " + text + "\n")
+	return err
+}
+
+// WriteCode writes code previously compiled with Capture.
+func (w *Writer) WriteCode(code string) error {
+	_, err := w.out.WriteString(code)
+	return err
+}
+
+// WriteShortCircuit writes & and | with the left operand on the stack, only running the
+// right operand code when the left operand does not already decide the result.
+func (w *Writer) WriteShortCircuit(op string, rhs string) error {
+	skip := fmt.Sprintf("SHORT_CIRCUIT_%d", w.shortCircuitCounter)
+	end := fmt.Sprintf("SHORT_CIRCUIT_END_%d", w.shortCircuitCounter)
+	w.shortCircuitCounter++
+
+	if op == "&" {
+		w.out.WriteString("not\n")
+	}
+	w.out.WriteString(fmt.Sprintf("if-goto %s\n", skip))
+	w.out.WriteString(rhs)
+	w.out.WriteString(fmt.Sprintf("goto %s\n", end))
+	w.out.WriteString(fmt.Sprintf("label %s\n", skip))
+	if op == "&" {
+		w.WriteKeyword("false")
+	} else {
+		w.WriteKeyword("true")
+	}
+	w.out.WriteString(fmt.Sprintf("label %s\n", end))
+
+	return nil
+}
+
 func (w *Writer) WriteIf(ifFn func() error, elseFn func() error) error {
-	ifFalse := fmt.Sprintf("IF_%d", ifCounter)
-	ifEnd := fmt.Sprintf("IF_END_%d", ifCounter)
+	ifFalse := fmt.Sprintf("IF_%d", w.ifCounter)
+	ifEnd := fmt.Sprintf("IF_END_%d", w.ifCounter)
 	labelFalse := fmt.Sprintf("label %s\n", ifFalse)
 	labelEnd := fmt.Sprintf("label %s\n", ifEnd)
-	ifCounter++
+	w.ifCounter++
 
 	w.out.WriteString("not\n")
 	w.out.WriteString(fmt.Sprintf("if-goto %s\n", ifFalse))
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// fileState is what tells a file changed, without reading it.
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// watch polls the sources for changes, rebuilding incrementally whenever a file is added,
+// changed or removed, and running the command after each successful build. Errors reading the
+// sources are reported as diagnostics, once until they change, and watching goes on.
+func watch(find func() ([]source, error), cacheDir string, jobs int, interval time.Duration, command string) {
+	var previous map[string]fileState
+	var lastErr string
+	for ; ; time.Sleep(interval) {
+		sources, err := find()
+		if err != nil {
+			if err.Error() != lastErr {
+				lastErr = err.Error()
+				reportError(err)
+			}
+			previous = nil
+			continue
+		}
+		current := snapshot(sources)
+		if sameSnapshot(previous, current) {
+			continue
+		}
+		previous = current
+		// the declarations are read again only when a file changed
+		if err := declare(sources); err != nil {
+			reportError(err)
+			continue
+		}
+		lastErr = ""
+
+		start := time.Now()
+		failed, err := incrementalBuild(cacheDir, sources, jobs)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error updating the build cache: %s\n", err)
+		}
+		status := "ok"
+		if failed > 0 {
+			status = fmt.Sprintf("%d of %d files failed", failed, len(sources))
+		}
+		flushDiagnostics()
+		fmt.Printf("[%s] build %s in %s\n", start.Format("15:04:05"), status, time.Since(start).Round(time.Millisecond))
+
+		if failed == 0 && command != "" {
+			runShell(command)
+		}
+	}
+}
+
+// reportError reports an error reading the sources as a diagnostic about the file it names.
+func reportError(err error) {
+	var filename string
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		filename = pathErr.Path
+	}
+	report(filename, nil, err)
+	flushDiagnostics()
+}
+
+func snapshot(sources []source) map[string]fileState {
+	states := make(map[string]fileState, len(sources))
+	for _, src := range sources {
+		info, err := os.Stat(src.filename)
+		if err != nil {
+			continue
+		}
+		states[src.filename] = fileState{modTime: info.ModTime(), size: info.Size()}
+	}
+	return states
+}
+
+func sameSnapshot(previous, current map[string]fileState) bool {
+	if previous == nil || len(previous) != len(current) {
+		return false
+	}
+	for filename, state := range current {
+		if previous[filename] != state {
+			return false
+		}
+	}
+	return true
+}
+
+func runShell(command string) {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", command, err)
+	}
+}